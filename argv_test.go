@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testClientSecret = "client-secret-material"
+	testAuthCode     = "auth-code"
+)
+
+// loginTokenHandler completes the authorization code flow, issuing an id token with the nonce the browser was sent.
+func loginTokenHandler(t *testing.T, refreshToken string, nonce *string, mu *sync.Mutex) testIssuerHandler {
+	return func(w http.ResponseWriter, r *http.Request, issuer string) {
+		r.ParseForm()
+		user, secret, _ := r.BasicAuth()
+		if r.Form.Get("code") != testAuthCode || user != clientID || secret != testClientSecret {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		claims := validTestClaims()
+		claims["iss"] = issuer
+		mu.Lock()
+		claims["nonce"] = *nonce
+		mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token":  "access-token-material",
+			"token_type":    "bearer",
+			"id_token":      signTestToken(t, claims),
+			"refresh_token": refreshToken,
+		})
+	}
+}
+
+// TestLoginKeepsSecretsOutOfArgv logs in with a fake browser and records the command line of every subprocess.
+// None may carry the client secret or a token, as any user of the machine can read them from ps or /proc.
+func TestLoginKeepsSecretsOutOfArgv(t *testing.T) {
+	var testCases = []struct {
+		description    string
+		refreshToken   string
+		execCredential bool
+	}{
+		{description: "oidc auth-provider", refreshToken: "refresh-token-material"},
+		{description: "id token only"},
+		{description: "exec credential", refreshToken: "refresh-token-material", execCredential: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			home, _ := ioutil.TempDir(os.TempDir(), "home")
+			defer os.RemoveAll(home)
+			for key, value := range map[string]string{"HOME": home, "KUBELOGIN": testClientSecret} {
+				defer os.Setenv(key, os.Getenv(key))
+				os.Setenv(key, value)
+			}
+
+			var mu sync.Mutex
+			var nonce string
+			var commandLines [][]string
+			issuer := newTestIssuer(t, nil, map[string]testIssuerHandler{
+				"/token": loginTokenHandler(t, tc.refreshToken, &nonce, &mu),
+			})
+			defer issuer.Close()
+
+			// the browser gets the authorization request and sends the code back to the callback server
+			defer func(original func(string, ...string) *exec.Cmd) { startCommand = original }(startCommand)
+			startCommand = func(name string, args ...string) *exec.Cmd {
+				mu.Lock()
+				defer mu.Unlock()
+				commandLines = append(commandLines, append([]string{name}, args...))
+				for _, arg := range args {
+					if authURL, err := url.Parse(arg); err == nil && strings.HasPrefix(arg, issuer.URL+"/auth") {
+						nonce = authURL.Query().Get("nonce")
+						callback := authURL.Query().Get("redirect_uri") + "?" + url.Values{
+							"code":  {testAuthCode},
+							"state": {authURL.Query().Get("state")},
+						}.Encode()
+						go func() {
+							if resp, err := http.Get(callback); err == nil {
+								resp.Body.Close()
+							}
+						}()
+					}
+				}
+				return exec.Command("true")
+			}
+
+			apiServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusCreated)
+			}))
+			defer apiServer.Close()
+			masterKubeconfig := filepath.Join(home, "kubeconfig")
+			os.Rename(writeSessionKubeconfig(t, apiServer, ""), masterKubeconfig)
+			defer os.Setenv("KUBECONFIG", os.Getenv("KUBECONFIG"))
+			os.Setenv("KUBECONFIG", masterKubeconfig)
+
+			configPath := filepath.Join(home, "config.json")
+			config, _ := json.Marshal(map[string]*configuration{
+				"config1": {Issuer: issuer.URL, Aliases: []string{"alias1"}, Loopback: true, ExecCredential: tc.execCredential},
+			})
+			ioutil.WriteFile(configPath, config, 0600)
+			defer os.Setenv(configPathEnv, os.Getenv(configPathEnv))
+			os.Setenv(configPathEnv, configPath)
+
+			result := &loginResult{}
+			if err := loginCluster(&options{}, []string{"alias1"}, result); err != nil {
+				t.Fatal(err)
+			}
+			assert.True(t, result.LoggedIn)
+
+			tokens, err := loadCachedTokens("config1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			secrets := []string{testClientSecret, tokens.IDToken}
+			if tc.refreshToken != "" {
+				secrets = append(secrets, tc.refreshToken)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			assert.NotEmpty(t, commandLines, "the browser should have been started")
+			for _, commandLine := range commandLines {
+				for _, arg := range commandLine {
+					for _, secret := range secrets {
+						assert.NotContains(t, arg, secret, "command line %q", commandLine)
+					}
+				}
+			}
+
+			written, _ := ioutil.ReadFile(result.Kubeconfig)
+			if !tc.execCredential {
+				assert.Contains(t, string(written), tokens.IDToken, "the credentials should be in the kubeconfig")
+			}
+			info, err := os.Stat(result.Kubeconfig)
+			assert.NoError(t, err)
+			assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), "the kubeconfig holding the credentials")
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	callbackHost = "127.0.0.1"
+	callbackPath = "/callback"
+	loginTimeout = 5 * time.Minute
+)
+
+const callbackSuccessPage = `<!DOCTYPE html>
+<html><head><title>kubectl-login</title></head>
+<body><p>Login successful. You can close this window and return to your terminal.</p></body></html>`
+
+// callbackServer is a short-lived HTTP listener on the loopback interface which receives
+// the authorization code from the issuer, so the user doesn't have to copy-paste tokens.
+type callbackServer struct {
+	listener net.Listener
+	server   *http.Server
+	state    string
+	result   chan callbackResult
+}
+
+type callbackResult struct {
+	code string
+	err  error
+}
+
+// newCallbackServer starts listening on 127.0.0.1. A port of 0 picks a free one.
+func newCallbackServer(port int, state string) (*callbackServer, error) {
+	listener, err := net.Listen("tcp", net.JoinHostPort(callbackHost, strconv.Itoa(port)))
+	if err != nil {
+		return nil, fmt.Errorf("cannot listen on %s:%d: %v", callbackHost, port, err)
+	}
+
+	s := &callbackServer{
+		listener: listener,
+		state:    state,
+		result:   make(chan callbackResult, 1),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(callbackPath, s.handleCallback)
+	s.server = &http.Server{Handler: mux}
+
+	go s.server.Serve(listener)
+	return s, nil
+}
+
+func (s *callbackServer) redirectURL() string {
+	return "http://" + s.listener.Addr().String() + callbackPath
+}
+
+func (s *callbackServer) handleCallback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if errCode := query.Get("error"); errCode != "" {
+		http.Error(w, "Login failed: "+errCode, http.StatusBadRequest)
+		s.deliver(callbackResult{err: fmt.Errorf("issuer returned %s: %s", errCode, query.Get("error_description"))})
+		return
+	}
+	if query.Get("state") != s.state {
+		http.Error(w, "Login failed: invalid state", http.StatusBadRequest)
+		s.deliver(callbackResult{err: errors.New("state in callback does not match")})
+		return
+	}
+	code := query.Get("code")
+	if code == "" {
+		http.Error(w, "Login failed: missing code", http.StatusBadRequest)
+		s.deliver(callbackResult{err: errors.New("no authorization code in callback")})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, callbackSuccessPage)
+	s.deliver(callbackResult{code: code})
+}
+
+// deliver keeps only the first callback, any later request is ignored.
+func (s *callbackServer) deliver(res callbackResult) {
+	select {
+	case s.result <- res:
+	default:
+	}
+}
+
+// waitForCode blocks until the browser hits the callback or the context is done.
+func (s *callbackServer) waitForCode(ctx context.Context) (string, error) {
+	select {
+	case res := <-s.result:
+		return res.code, res.err
+	case <-ctx.Done():
+		return "", fmt.Errorf("timed out waiting for the login callback: %v", ctx.Err())
+	}
+}
+
+func (s *callbackServer) close() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	s.server.Shutdown(ctx)
+}
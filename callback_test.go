@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCallbackServerRedirectURL(t *testing.T) {
+	server, err := newCallbackServer(0, "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.close()
+
+	redirectURL, err := url.Parse(server.redirectURL())
+	assert.NoError(t, err)
+	assert.Equal(t, "http", redirectURL.Scheme)
+	assert.Equal(t, callbackHost, redirectURL.Hostname())
+	assert.NotEqual(t, "0", redirectURL.Port())
+	assert.Equal(t, callbackPath, redirectURL.Path)
+}
+
+func TestCallbackServerWaitForCode(t *testing.T) {
+	var testCases = []struct {
+		description   string
+		query         url.Values
+		expectedCode  string
+		expectedError string
+	}{
+		{
+			description:  "code with matching state",
+			query:        url.Values{"state": {"expected-state"}, "code": {"auth-code"}},
+			expectedCode: "auth-code",
+		},
+		{
+			description:   "state does not match",
+			query:         url.Values{"state": {"other-state"}, "code": {"auth-code"}},
+			expectedError: "state",
+		},
+		{
+			description:   "missing code",
+			query:         url.Values{"state": {"expected-state"}},
+			expectedError: "no authorization code",
+		},
+		{
+			description:   "issuer returned an error",
+			query:         url.Values{"error": {"access_denied"}, "error_description": {"user said no"}},
+			expectedError: "access_denied",
+		},
+	}
+	for _, tc := range testCases {
+		server, err := newCallbackServer(0, "expected-state")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := http.Get(server.redirectURL() + "?" + tc.query.Encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		code, err := server.waitForCode(ctx)
+		cancel()
+		server.close()
+
+		assert.Equal(t, tc.expectedCode, code, "Scenario: "+tc.description)
+		if tc.expectedError == "" {
+			assert.NoError(t, err, "Scenario: "+tc.description)
+			assert.Equal(t, http.StatusOK, resp.StatusCode, "Scenario: "+tc.description)
+		} else {
+			assert.True(t, err != nil && strings.Contains(err.Error(), tc.expectedError), "Scenario: "+tc.description)
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "Scenario: "+tc.description)
+		}
+	}
+}
+
+func TestCallbackServerTimeout(t *testing.T) {
+	server, err := newCallbackServer(0, "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = server.waitForCode(ctx)
+	assert.Error(t, err)
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+const (
+	configCommandName  = "config"
+	validateSubcommand = "validate"
+)
+
+var yamlErrorLine = regexp.MustCompile(`line (\d+)`)
+
+// diagnostic is a problem found in the config file. Line and Column are 1-based, 0 means unknown.
+type diagnostic struct {
+	Line    int
+	Column  int
+	Message string
+}
+
+func (d diagnostic) format(configPath string) string {
+	switch {
+	case d.Line == 0:
+		return fmt.Sprintf("%s: %s", configPath, d.Message)
+	case d.Column == 0:
+		return fmt.Sprintf("%s:%d: %s", configPath, d.Line, d.Message)
+	default:
+		return fmt.Sprintf("%s:%d:%d: %s", configPath, d.Line, d.Column, d.Message)
+	}
+}
+
+// configCommand implements "kubectl-login config validate [path...]". Without paths it validates the config file in use.
+// It exits with exitConfigProblems when problems were found and exitConfig when a file cannot be read, so it can be
+// used as a pre-commit hook.
+func configCommand(args []string) error {
+	if len(args) == 0 || args[0] != validateSubcommand {
+		return errorf(exitUsage, "usage: kubectl-login %s %s [path...]", configCommandName, validateSubcommand)
+	}
+
+	paths := args[1:]
+	if len(paths) == 0 {
+		paths = []string{getConfigPath()}
+	}
+	switch code := validateConfigFiles(os.Stdout, paths); code {
+	case exitOK:
+		return nil
+	case exitConfig:
+		return errorf(code, "cannot read every config file")
+	default:
+		return errorf(code, "problems found in the config files")
+	}
+}
+
+func validateConfigFiles(w io.Writer, paths []string) exitCode {
+	exitCode := exitOK
+	for _, configPath := range paths {
+		data, err := ioutil.ReadFile(configPath)
+		if err != nil {
+			fmt.Fprintf(w, "%s: cannot read config file: %v\n", configPath, err)
+			exitCode = exitConfig
+			continue
+		}
+		diagnostics := validateConfig(configPath, data)
+		for _, d := range diagnostics {
+			fmt.Fprintln(w, d.format(configPath))
+		}
+		if len(diagnostics) > 0 && exitCode == exitOK {
+			exitCode = exitConfigProblems
+		}
+	}
+	return exitCode
+}
+
+// validateConfig reports every problem it can find rather than stopping at the first one.
+// JSON is valid YAML, so both formats are checked on the same YAML node tree, which keeps positions.
+func validateConfig(configPath string, data []byte) []diagnostic {
+	ext := strings.ToLower(filepath.Ext(configPath))
+	if ext != ".yaml" && ext != ".yml" {
+		var v interface{}
+		if err := json.Unmarshal(data, &v); err != nil {
+			return []diagnostic{jsonDiagnostic(data, err)}
+		}
+	}
+
+	var root yamlv3.Node
+	if err := yamlv3.Unmarshal(data, &root); err != nil {
+		d := diagnostic{Message: err.Error()}
+		if m := yamlErrorLine.FindStringSubmatch(err.Error()); m != nil {
+			d.Line, _ = strconv.Atoi(m[1])
+		}
+		return []diagnostic{d}
+	}
+	if len(root.Content) == 0 {
+		return []diagnostic{{Message: "config file is empty"}}
+	}
+	clusters := root.Content[0]
+	if clusters.Kind != yamlv3.MappingNode {
+		return []diagnostic{{Line: clusters.Line, Column: clusters.Column, Message: "expected a mapping of cluster names to cluster configs"}}
+	}
+
+	var diagnostics []diagnostic
+	clusterKeys := map[string]*yamlv3.Node{}
+	for i := 0; i+1 < len(clusters.Content); i += 2 {
+		key := clusters.Content[i]
+		if previous, ok := clusterKeys[key.Value]; ok {
+			diagnostics = append(diagnostics, diagnostic{key.Line, key.Column,
+				fmt.Sprintf("cluster %q is already defined at line %d", key.Value, previous.Line)})
+			continue
+		}
+		clusterKeys[key.Value] = key
+	}
+
+	aliasOwners := map[string]string{}
+	aliasNodes := map[string]*yamlv3.Node{}
+	for i := 0; i+1 < len(clusters.Content); i += 2 {
+		key, value := clusters.Content[i], clusters.Content[i+1]
+		diagnostics = append(diagnostics, validateCluster(key, value)...)
+
+		for _, alias := range aliasNodesOf(value) {
+			if owner, ok := aliasOwners[alias.Value]; ok && owner != key.Value {
+				diagnostics = append(diagnostics, diagnostic{alias.Line, alias.Column,
+					fmt.Sprintf("alias %q of cluster %q is also an alias of cluster %q (line %d)", alias.Value, key.Value, owner, aliasNodes[alias.Value].Line)})
+			} else if !ok {
+				aliasOwners[alias.Value] = key.Value
+				aliasNodes[alias.Value] = alias
+			}
+			if other, ok := clusterKeys[alias.Value]; ok && alias.Value != key.Value {
+				diagnostics = append(diagnostics, diagnostic{alias.Line, alias.Column,
+					fmt.Sprintf("alias %q of cluster %q is the name of another cluster (line %d)", alias.Value, key.Value, other.Line)})
+			}
+		}
+	}
+
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		if diagnostics[i].Line != diagnostics[j].Line {
+			return diagnostics[i].Line < diagnostics[j].Line
+		}
+		return diagnostics[i].Column < diagnostics[j].Column
+	})
+	return diagnostics
+}
+
+func validateCluster(key, value *yamlv3.Node) []diagnostic {
+	if value.Kind != yamlv3.MappingNode {
+		return []diagnostic{{value.Line, value.Column, fmt.Sprintf("cluster %q: expected a mapping of settings", key.Value)}}
+	}
+
+	var diagnostics []diagnostic
+	known := knownConfigFields()
+	fields := map[string]*yamlv3.Node{}
+	for i := 0; i+1 < len(value.Content); i += 2 {
+		field := value.Content[i]
+		if !known[field.Value] {
+			diagnostics = append(diagnostics, diagnostic{field.Line, field.Column,
+				fmt.Sprintf("cluster %q: unknown field %q", key.Value, field.Value)})
+			continue
+		}
+		fields[field.Value] = value.Content[i+1]
+	}
+
+	var config configuration
+	if err := value.Decode(&config); err != nil {
+		diagnostics = append(diagnostics, diagnostic{value.Line, value.Column, fmt.Sprintf("cluster %q: %v", key.Value, err)})
+		return diagnostics
+	}
+
+	if config.Issuer == "" {
+		diagnostics = append(diagnostics, diagnostic{key.Line, key.Column, fmt.Sprintf("cluster %q: missing issuer", key.Value)})
+	} else if issuerURL, err := url.Parse(config.Issuer); err != nil || issuerURL.Scheme != "https" {
+		node := fields["issuer"]
+		diagnostics = append(diagnostics, diagnostic{node.Line, node.Column, fmt.Sprintf("cluster %q: issuer %q is not an https URL", key.Value, config.Issuer)})
+	}
+	// only the dex-redirect paste flow needs a redirect URL
+	if config.RedirectURL == "" && !config.Loopback && !config.DeviceCode {
+		diagnostics = append(diagnostics, diagnostic{key.Line, key.Column, fmt.Sprintf("cluster %q: missing redirectUrl", key.Value)})
+	}
+	if config.PKCE && !config.Loopback {
+		node := fields["pkce"]
+		diagnostics = append(diagnostics, diagnostic{node.Line, node.Column, fmt.Sprintf("cluster %q: pkce requires loopback", key.Value)})
+	}
+	if config.loginSecretSourceCount() > 1 {
+		diagnostics = append(diagnostics, diagnostic{key.Line, key.Column, fmt.Sprintf("cluster %q: more than one loginSecret source", key.Value)})
+	}
+	if len(config.Aliases) == 0 {
+		diagnostics = append(diagnostics, diagnostic{key.Line, key.Column, fmt.Sprintf("cluster %q: no aliases", key.Value)})
+	}
+	return diagnostics
+}
+
+func aliasNodesOf(cluster *yamlv3.Node) []*yamlv3.Node {
+	if cluster.Kind != yamlv3.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(cluster.Content); i += 2 {
+		if cluster.Content[i].Value == "aliases" && cluster.Content[i+1].Kind == yamlv3.SequenceNode {
+			return cluster.Content[i+1].Content
+		}
+	}
+	return nil
+}
+
+// knownConfigFields is derived from the configuration struct, so new settings don't have to be listed twice.
+func knownConfigFields() map[string]bool {
+	known := map[string]bool{}
+	t := reflect.TypeOf(configuration{})
+	for i := 0; i < t.NumField(); i++ {
+		if name := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]; name != "" {
+			known[name] = true
+		}
+	}
+	return known
+}
+
+func jsonDiagnostic(data []byte, err error) diagnostic {
+	var offset int64
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		offset = e.Offset
+	case *json.UnmarshalTypeError:
+		offset = e.Offset
+	default:
+		return diagnostic{Message: err.Error()}
+	}
+	// the offset is just past the offending character
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := len(before) - 1 - bytes.LastIndexByte(before, '\n')
+	return diagnostic{line, column, err.Error()}
+}
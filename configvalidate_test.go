@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateConfig(t *testing.T) {
+	var testCases = []struct {
+		description string
+		path        string
+		contents    string
+		expected    []diagnostic
+	}{
+		{
+			description: "valid json",
+			path:        "config.json",
+			contents: `{
+  "cluster-1": {
+    "issuer": "https://dex-1.ft.com",
+    "redirectUrl": "https://dex-redirect-1.ft.com/callback",
+    "aliases": ["test"]
+  }
+}`,
+			expected: nil,
+		},
+		{
+			description: "valid yaml with loopback and no redirectUrl",
+			path:        "config.yaml",
+			contents: `cluster-1:
+  issuer: https://dex-1.ft.com
+  loopback: true
+  pkce: true
+  aliases: [test]
+`,
+			expected: nil,
+		},
+		{
+			description: "json syntax error",
+			path:        "config.json",
+			contents: `{
+  "cluster-1": {
+    "issuer": "https://dex-1.ft.com",,
+  }
+}`,
+			expected: []diagnostic{{3, 38, "invalid character ',' looking for beginning of object key string"}},
+		},
+		{
+			description: "yaml syntax error",
+			path:        "config.yaml",
+			contents: `cluster-1:
+  issuer: https://dex-1.ft.com
+ aliases: [test]
+`,
+			expected: []diagnostic{{2, 0, "yaml: line 2: did not find expected key"}},
+		},
+		{
+			description: "unknown field, missing issuer and redirectUrl",
+			path:        "config.yaml",
+			contents: `cluster-1:
+  isuer: https://dex-1.ft.com
+  aliases: [test]
+`,
+			expected: []diagnostic{
+				{1, 1, `cluster "cluster-1": missing issuer`},
+				{1, 1, `cluster "cluster-1": missing redirectUrl`},
+				{2, 3, `cluster "cluster-1": unknown field "isuer"`},
+			},
+		},
+		{
+			description: "non-https issuer and pkce without loopback",
+			path:        "config.yaml",
+			contents: `cluster-1:
+  issuer: http://dex-1.ft.com
+  redirectUrl: https://dex-redirect-1.ft.com/callback
+  pkce: true
+  aliases: [test]
+`,
+			expected: []diagnostic{
+				{2, 11, `cluster "cluster-1": issuer "http://dex-1.ft.com" is not an https URL`},
+				{4, 9, `cluster "cluster-1": pkce requires loopback`},
+			},
+		},
+		{
+			description: "alias shared by two clusters and alias naming another cluster",
+			path:        "config.yaml",
+			contents: `cluster-1:
+  issuer: https://dex-1.ft.com
+  redirectUrl: https://dex-redirect-1.ft.com/callback
+  aliases: [test, cluster-2]
+cluster-2:
+  issuer: https://dex-2.ft.com
+  redirectUrl: https://dex-redirect-2.ft.com/callback
+  aliases: [prod, test]
+`,
+			expected: []diagnostic{
+				{4, 19, `alias "cluster-2" of cluster "cluster-1" is the name of another cluster (line 5)`},
+				{8, 19, `alias "test" of cluster "cluster-2" is also an alias of cluster "cluster-1" (line 4)`},
+			},
+		},
+		{
+			description: "more than one loginSecret source",
+			path:        "config.yaml",
+			contents: `cluster-1:
+  issuer: https://dex-1.ft.com
+  redirectUrl: https://dex-redirect-1.ft.com/callback
+  loginSecret: terces
+  loginSecretCommand: pass show dex/kubectl-login
+  aliases: [test]
+`,
+			expected: []diagnostic{{1, 1, `cluster "cluster-1": more than one loginSecret source`}},
+		},
+		{
+			description: "duplicate cluster and wrong type",
+			path:        "config.yaml",
+			contents: `cluster-1:
+  issuer: https://dex-1.ft.com
+  redirectUrl: https://dex-redirect-1.ft.com/callback
+  aliases: test
+cluster-1:
+  issuer: https://dex-1.ft.com
+`,
+			expected: []diagnostic{
+				{2, 3, `cluster "cluster-1": yaml: unmarshal errors:
+  line 4: cannot unmarshal !!str ` + "`test`" + ` into []string`},
+				{5, 1, `cluster "cluster-1" is already defined at line 1`},
+				{5, 1, `cluster "cluster-1": missing redirectUrl`},
+				{5, 1, `cluster "cluster-1": no aliases`},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		actual := validateConfig(tc.path, []byte(tc.contents))
+		assert.Equal(t, tc.expected, actual, "Scenario: "+tc.description)
+	}
+}
+
+func TestValidateConfigFiles(t *testing.T) {
+	dir, _ := ioutil.TempDir(os.TempDir(), "config")
+	defer os.RemoveAll(dir)
+	valid := filepath.Join(dir, "valid.yaml")
+	ioutil.WriteFile(valid, []byte("cluster-1:\n  issuer: https://dex-1.ft.com\n  redirectUrl: https://r.ft.com\n  aliases: [test]\n"), 0644)
+	invalid := filepath.Join(dir, "invalid.yaml")
+	ioutil.WriteFile(invalid, []byte("cluster-1:\n  issuer: https://dex-1.ft.com\n  aliases: [test]\n"), 0644)
+
+	var out bytes.Buffer
+	assert.Equal(t, exitOK, validateConfigFiles(&out, []string{valid}))
+	assert.Empty(t, out.String())
+
+	assert.Equal(t, exitConfigProblems, validateConfigFiles(&out, []string{valid, invalid}))
+	assert.Equal(t, invalid+`:1:1: cluster "cluster-1": missing redirectUrl`+"\n", out.String())
+
+	out.Reset()
+	assert.Equal(t, exitConfig, validateConfigFiles(&out, []string{filepath.Join(dir, "missing.yaml"), invalid}))
+}
+
+func TestKnownConfigFields(t *testing.T) {
+	known := knownConfigFields()
+	for _, field := range []string{"issuer", "redirectUrl", "loginSecret", "aliases", "loopback", "pkce"} {
+		assert.True(t, known[field], field)
+	}
+	assert.False(t, known["isuer"])
+}
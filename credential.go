@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+)
+
+const (
+	execCredentialAPIVersion = "client.authentication.k8s.io/v1"
+	execCredentialKind       = "ExecCredential"
+	getTokenCommandName      = "get-token"
+	// tokens this close to their expiry are refreshed, so kubectl doesn't send one that expires in flight
+	tokenExpiryLeeway = time.Minute
+)
+
+type execCredential struct {
+	APIVersion string               `json:"apiVersion"`
+	Kind       string               `json:"kind"`
+	Status     execCredentialStatus `json:"status"`
+}
+
+type execCredentialStatus struct {
+	Token               string `json:"token"`
+	ExpirationTimestamp string `json:"expirationTimestamp,omitempty"`
+}
+
+// getTokenCommand implements "kubectl-login get-token <alias>", the exec credential plugin kubectl calls
+// for clusters logged in with execCredential. It prints the cached id token, refreshing it first when needed.
+func getTokenCommand(args []string) error {
+	alias, err := getAlias(args)
+	if err != nil {
+		return err
+	}
+	rawConfig, err := getRawConfig()
+	if err != nil {
+		return err
+	}
+	config, cluster, err := getConfigByAlias(alias, rawConfig)
+	if err != nil {
+		return err
+	}
+
+	tokens, err := loadCachedTokens(cluster)
+	if err != nil {
+		return errorf(exitAuth, "no cached tokens for %s, run 'kubectl-login %s' to login: %w", cluster, alias, err)
+	}
+
+	expiry, err := idTokenExpiry(tokens.IDToken)
+	if err != nil || time.Until(expiry) < tokenExpiryLeeway {
+		clientSecret, err := getKubeLogin(config)
+		if err != nil {
+			return err
+		}
+		tokens, err = refreshCachedTokens(context.Background(), config, clientSecret, tokens)
+		if err != nil {
+			return fmt.Errorf("cannot refresh the token for %s, run 'kubectl-login %s' to login: %w", cluster, alias, err)
+		}
+		if err := saveCachedTokens(cluster, tokens); err != nil {
+			logger.Printf("warning: cannot cache the refreshed token for %s: %v", cluster, err)
+		}
+		expiry, _ = idTokenExpiry(tokens.IDToken)
+	}
+
+	if err := writeExecCredential(os.Stdout, tokens.IDToken, expiry); err != nil {
+		return fmt.Errorf("cannot write ExecCredential: %w", err)
+	}
+	return nil
+}
+
+func writeExecCredential(w io.Writer, idToken string, expiry time.Time) error {
+	credential := execCredential{
+		APIVersion: execCredentialAPIVersion,
+		Kind:       execCredentialKind,
+		Status:     execCredentialStatus{Token: idToken},
+	}
+	if !expiry.IsZero() {
+		credential.Status.ExpirationTimestamp = expiry.UTC().Format(time.RFC3339)
+	}
+	return json.NewEncoder(w).Encode(credential)
+}
+
+// setExecCredential points the kubectl-login user at the get-token command instead of storing tokens in the kubeconfig.
+func setExecCredential(userName, alias, config string) error {
+	err := updateKubeconfig(config, func(k *kubeconfig) {
+		user := k.user(userName)
+		user.Token = ""
+		user.AuthProvider = nil
+		user.Exec = &kubeExec{
+			APIVersion:      execCredentialAPIVersion,
+			Command:         execCommand(),
+			Args:            []string{getTokenCommandName, alias},
+			InteractiveMode: "Never",
+		}
+	})
+	if err != nil {
+		return errorf(exitKubeconfig, "cannot set kubectl exec credentials: %w", err)
+	}
+	return nil
+}
+
+// execCommand prefers the plain command name when kubectl-login is on the PATH, so the kubeconfig survives upgrades.
+func execCommand() string {
+	if _, err := exec.LookPath("kubectl-login"); err == nil {
+		return "kubectl-login"
+	}
+	if path, err := os.Executable(); err == nil {
+		return path
+	}
+	return "kubectl-login"
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteExecCredential(t *testing.T) {
+	expiry := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	var out bytes.Buffer
+	assert.NoError(t, writeExecCredential(&out, "id-token", expiry))
+
+	var credential map[string]interface{}
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &credential))
+	assert.Equal(t, "client.authentication.k8s.io/v1", credential["apiVersion"])
+	assert.Equal(t, "ExecCredential", credential["kind"])
+	assert.Equal(t, map[string]interface{}{
+		"token":               "id-token",
+		"expirationTimestamp": "2030-01-02T03:04:05Z",
+	}, credential["status"])
+}
+
+func TestSetExecCredential(t *testing.T) {
+	path := writeTempKubeconfig(t, testKubeconfig)
+	defer os.Remove(path)
+
+	setExecCredential(clientID, "alias1", path)
+
+	k, err := loadKubeconfig(path)
+	assert.NoError(t, err)
+	user := k.user(clientID)
+	assert.Empty(t, user.Token)
+	assert.Nil(t, user.AuthProvider)
+	if assert.NotNil(t, user.Exec) {
+		assert.Equal(t, "client.authentication.k8s.io/v1", user.Exec.APIVersion)
+		assert.Equal(t, []string{"get-token", "alias1"}, user.Exec.Args)
+		assert.Equal(t, "Never", user.Exec.InteractiveMode)
+		assert.NotEmpty(t, user.Exec.Command)
+	}
+}
+
+func TestGetTokenCommandCachedToken(t *testing.T) {
+	home, _ := ioutil.TempDir(os.TempDir(), "home")
+	defer os.RemoveAll(home)
+	marshaledConfig, _ := json.Marshal(validConfig)
+	ioutil.WriteFile(filepath.Join(home, configFile), marshaledConfig, 0644)
+
+	originalHome, originalStdout := os.Getenv("HOME"), os.Stdout
+	defer func() {
+		os.Setenv("HOME", originalHome)
+		os.Stdout = originalStdout
+	}()
+	os.Setenv("HOME", home)
+	idToken := signTestToken(t, validTestClaims())
+	assert.NoError(t, saveCachedTokens("config1", &cachedTokens{IDToken: idToken, RefreshToken: "refresh"}))
+
+	stdout, _ := os.Create(filepath.Join(home, "stdout"))
+	os.Stdout = stdout
+	assert.NoError(t, getTokenCommand([]string{"alias1"}))
+	stdout.Close()
+	output, _ := ioutil.ReadFile(stdout.Name())
+
+	var credential execCredential
+	assert.NoError(t, json.NewDecoder(bytes.NewReader(output)).Decode(&credential))
+	assert.Equal(t, "ExecCredential", credential.Kind)
+	assert.Equal(t, idToken, credential.Status.Token)
+	assert.NotEmpty(t, credential.Status.ExpirationTimestamp)
+}
+
+func TestGetTokenCommandNoCachedToken(t *testing.T) {
+	home, _ := ioutil.TempDir(os.TempDir(), "home")
+	defer os.RemoveAll(home)
+	marshaledConfig, _ := json.Marshal(validConfig)
+	ioutil.WriteFile(filepath.Join(home, configFile), marshaledConfig, 0644)
+
+	originalHome := os.Getenv("HOME")
+	defer os.Setenv("HOME", originalHome)
+	os.Setenv("HOME", home)
+
+	err := getTokenCommand([]string{"alias1"})
+	assert.Error(t, err, "getTokenCommand should fail when there are no cached tokens")
+	assert.Equal(t, exitAuth, exitCodeOf(err))
+}
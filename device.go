@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/coreos/go-oidc"
+	"golang.org/x/oauth2"
+)
+
+// deviceAuthorizationEndpoint returns the RFC 8628 endpoint advertised in the provider's discovery document.
+func deviceAuthorizationEndpoint(provider *oidc.Provider) (string, error) {
+	var claims struct {
+		DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+	}
+	if err := provider.Claims(&claims); err != nil {
+		return "", err
+	}
+	if claims.DeviceAuthorizationEndpoint == "" {
+		return "", errors.New("issuer does not advertise a device_authorization_endpoint")
+	}
+	return claims.DeviceAuthorizationEndpoint, nil
+}
+
+// deviceCodeToken runs the device authorization grant: it asks for a user code, tells the user
+// where to enter it and polls the token endpoint until the login is approved, denied or expires.
+// The browser isn't opened, as the user usually logs in on another machine.
+func deviceCodeToken(ctx context.Context, oauth2Config *oauth2.Config, authParams []oauth2.AuthCodeOption, prompt *loginPrompt) (*oauth2.Token, error) {
+	deviceAuth, err := oauth2Config.DeviceAuth(ctx, authParams...)
+	if err != nil {
+		return nil, fmt.Errorf("cannot start device authorization: %v", err)
+	}
+
+	prompt.url = deviceAuth.VerificationURI
+	fmt.Fprintf(prompt.out, "To login, visit %s and enter the code %s\n", deviceAuth.VerificationURI, deviceAuth.UserCode)
+	if deviceAuth.VerificationURIComplete != "" {
+		prompt.url = deviceAuth.VerificationURIComplete
+		fmt.Fprintf(prompt.out, "or open %s\n", deviceAuth.VerificationURIComplete)
+	}
+
+	token, err := oauth2Config.DeviceAccessToken(ctx, deviceAuth)
+	if err != nil {
+		return nil, fmt.Errorf("device login did not complete: %v", err)
+	}
+	return token, nil
+}
+
+// loginWithDeviceCode is used where no browser can be opened, e.g. on the jumpbox.
+func loginWithDeviceCode(ctx context.Context, provider *oidc.Provider, oauth2Config *oauth2.Config, authParams []oauth2.AuthCodeOption, prompt *loginPrompt) (string, string, error) {
+	endpoint, err := deviceAuthorizationEndpoint(provider)
+	if err != nil {
+		return "", "", errorf(exitConfig, "cannot use device code login: %w", err)
+	}
+	oauth2Config.Endpoint.DeviceAuthURL = endpoint
+
+	token, err := deviceCodeToken(ctx, oauth2Config, authParams, prompt)
+	if err != nil {
+		return "", "", errorf(exitAuth, "%w", err)
+	}
+	rawIdToken, refreshToken, err := tokensFromResponse(token)
+	if err != nil {
+		return "", "", errorf(exitAuth, "%w", err)
+	}
+	return rawIdToken, refreshToken, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/coreos/go-oidc"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
+)
+
+func newDeviceTestIssuer(t *testing.T, advertiseDevice bool, tokenResponses []string) *httptest.Server {
+	handlers := map[string]testIssuerHandler{
+		"/device/code": func(w http.ResponseWriter, r *http.Request, issuer string) {
+			w.Write([]byte(`{"device_code":"dev-code","user_code":"ABCD-EFGH","verification_uri":"` + issuer + `/device","interval":1,"expires_in":30}`))
+		},
+		"/token": func(w http.ResponseWriter, r *http.Request, issuer string) {
+			r.ParseForm()
+			if r.Form.Get("device_code") != "dev-code" {
+				t.Errorf("unexpected device_code %q", r.Form.Get("device_code"))
+			}
+			response := tokenResponses[0]
+			if len(tokenResponses) > 1 {
+				tokenResponses = tokenResponses[1:]
+			}
+			if strings.Contains(response, "error") {
+				w.WriteHeader(http.StatusBadRequest)
+			}
+			w.Write([]byte(response))
+		},
+	}
+	if advertiseDevice {
+		handlers["/.well-known/openid-configuration"] = func(w http.ResponseWriter, r *http.Request, issuer string) {
+			discovery := testDiscovery(issuer)
+			discovery["device_authorization_endpoint"] = issuer + "/device/code"
+			json.NewEncoder(w).Encode(discovery)
+		}
+	}
+	return newTestIssuer(t, nil, handlers)
+}
+
+func TestDeviceAuthorizationEndpoint(t *testing.T) {
+	var testCases = []struct {
+		description     string
+		advertiseDevice bool
+		expectError     bool
+	}{
+		{
+			description:     "endpoint advertised",
+			advertiseDevice: true,
+		},
+		{
+			description:     "endpoint not advertised",
+			advertiseDevice: false,
+			expectError:     true,
+		},
+	}
+	for _, tc := range testCases {
+		server := newDeviceTestIssuer(t, tc.advertiseDevice, nil)
+		provider, err := oidc.NewProvider(context.Background(), server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		endpoint, err := deviceAuthorizationEndpoint(provider)
+		if tc.expectError {
+			assert.Error(t, err, "Scenario: "+tc.description)
+		} else {
+			assert.NoError(t, err, "Scenario: "+tc.description)
+			assert.Equal(t, server.URL+"/device/code", endpoint, "Scenario: "+tc.description)
+		}
+		server.Close()
+	}
+}
+
+func TestDeviceCodeTokenSuccess(t *testing.T) {
+	server := newDeviceTestIssuer(t, true, []string{
+		`{"error":"authorization_pending"}`,
+		`{"access_token":"access","token_type":"bearer","refresh_token":"refresh","id_token":"id.token.value"}`,
+	})
+	defer server.Close()
+
+	oauth2Config := &oauth2.Config{
+		ClientID: clientID,
+		Endpoint: oauth2.Endpoint{TokenURL: server.URL + "/token", DeviceAuthURL: server.URL + "/device/code"},
+	}
+	var out strings.Builder
+	prompt := &loginPrompt{out: &out}
+	token, err := deviceCodeToken(context.Background(), oauth2Config, nil, prompt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Contains(t, out.String(), "ABCD-EFGH")
+	assert.Contains(t, out.String(), server.URL+"/device")
+	assert.Equal(t, server.URL+"/device", prompt.url)
+
+	rawIdToken, refreshToken, err := tokensFromResponse(token)
+	assert.NoError(t, err)
+	assert.Equal(t, "id.token.value", rawIdToken)
+	assert.Equal(t, "refresh", refreshToken)
+}
+
+func TestDeviceCodeTokenDenied(t *testing.T) {
+	server := newDeviceTestIssuer(t, true, []string{`{"error":"access_denied"}`})
+	defer server.Close()
+
+	oauth2Config := &oauth2.Config{
+		ClientID: clientID,
+		Endpoint: oauth2.Endpoint{TokenURL: server.URL + "/token", DeviceAuthURL: server.URL + "/device/code"},
+	}
+	_, err := deviceCodeToken(context.Background(), oauth2Config, nil, &loginPrompt{out: ioutil.Discard})
+	assert.Error(t, err)
+}
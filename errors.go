@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// exitCode tells the wrapper scripts why kubectl-login failed. The values are documented in the README,
+// so they must not change.
+type exitCode int
+
+const (
+	exitOK exitCode = 0
+	// exitFailure is used for errors that don't fall in any of the categories below.
+	exitFailure       exitCode = 1
+	exitUsage         exitCode = 2
+	exitConfig        exitCode = 3
+	exitAliasNotFound exitCode = 4
+	exitAuth          exitCode = 5
+	exitNetwork       exitCode = 6
+	exitKubeconfig    exitCode = 7
+	exitKubectl       exitCode = 8
+	// exitConfigProblems is returned by "config validate" when it found problems in the config files.
+	exitConfigProblems exitCode = 9
+)
+
+// commandError is an error with the exit code it should end kubectl-login with.
+// Wrapping it with fmt.Errorf and %w keeps the exit code.
+type commandError struct {
+	code exitCode
+	err  error
+}
+
+func (e *commandError) Error() string {
+	return e.err.Error()
+}
+
+func (e *commandError) Unwrap() error {
+	return e.err
+}
+
+// errorf formats the error like fmt.Errorf, so %w can be used to keep the cause.
+func errorf(code exitCode, format string, args ...interface{}) error {
+	return &commandError{code: code, err: fmt.Errorf(format, args...)}
+}
+
+// exitCodeOf returns the exit code of the outermost commandError in err's chain, or exitFailure if there is none.
+func exitCodeOf(err error) exitCode {
+	if err == nil {
+		return exitOK
+	}
+	var commandErr *commandError
+	if errors.As(err, &commandErr) {
+		return commandErr.code
+	}
+	return exitFailure
+}
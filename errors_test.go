@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExitCodeOf(t *testing.T) {
+	var testCases = []struct {
+		description  string
+		err          error
+		expectedCode exitCode
+	}{
+		{
+			description:  "no error",
+			err:          nil,
+			expectedCode: exitOK,
+		},
+		{
+			description:  "plain error",
+			err:          errors.New("boom"),
+			expectedCode: exitFailure,
+		},
+		{
+			description:  "command error",
+			err:          errorf(exitNetwork, "issuer unreachable"),
+			expectedCode: exitNetwork,
+		},
+		{
+			description:  "wrapped command error",
+			err:          fmt.Errorf("cannot login: %w", errorf(exitAuth, "access denied")),
+			expectedCode: exitAuth,
+		},
+		{
+			description:  "outermost command error wins",
+			err:          errorf(exitConfig, "bad secret: %w", errorf(exitFailure, "command failed")),
+			expectedCode: exitConfig,
+		},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expectedCode, exitCodeOf(tc.err), "Scenario: "+tc.description)
+	}
+}
+
+func TestErrorfKeepsCause(t *testing.T) {
+	cause := os.ErrNotExist
+	err := errorf(exitKubeconfig, "cannot open kubeconfig: %w", cause)
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+	assert.Equal(t, "cannot open kubeconfig: "+cause.Error(), err.Error())
+}
+
+func TestRunExitCodes(t *testing.T) {
+	dir, _ := ioutil.TempDir(os.TempDir(), "config")
+	defer os.RemoveAll(dir)
+	configPath := filepath.Join(dir, "config.json")
+	marshaledConfig, _ := json.Marshal(validConfig)
+	ioutil.WriteFile(configPath, marshaledConfig, 0644)
+
+	originalConfig := os.Getenv("KUBECTL_LOGIN_CONFIG")
+	defer os.Setenv("KUBECTL_LOGIN_CONFIG", originalConfig)
+
+	os.Setenv("KUBECTL_LOGIN_CONFIG", configPath)
+	assert.Equal(t, exitUsage, exitCodeOf(run(nil)), "no alias")
+	assert.Equal(t, exitAliasNotFound, exitCodeOf(run([]string{"unknown"})), "unknown alias")
+	assert.Equal(t, exitUsage, exitCodeOf(run([]string{listCommandName, "--output", "xml"})), "unknown output format")
+	assert.Equal(t, exitUsage, exitCodeOf(run([]string{configCommandName})), "no config subcommand")
+	assert.Equal(t, exitOK, exitCodeOf(run([]string{configCommandName, validateSubcommand})), "valid config file")
+	invalidPath := filepath.Join(dir, "invalid.json")
+	ioutil.WriteFile(invalidPath, []byte(`{"cluster-1": {"aliases": ["test"]}}`), 0644)
+	assert.Equal(t, exitConfigProblems, exitCodeOf(run([]string{configCommandName, validateSubcommand, invalidPath})), "problems in the config file")
+	assert.Equal(t, exitConfig, exitCodeOf(run([]string{configCommandName, validateSubcommand, filepath.Join(dir, "missing.json")})), "unreadable config file")
+
+	os.Setenv("KUBECTL_LOGIN_CONFIG", filepath.Join(dir, "missing.json"))
+	assert.Equal(t, exitConfig, exitCodeOf(run([]string{"alias1"})), "missing config file")
+}
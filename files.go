@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// lockTimeout is how long a login waits for another one writing the same kubeconfig.
+var lockTimeout = 10 * time.Second
+
+const lockRetryInterval = 50 * time.Millisecond
+
+// writeFileAtomic writes data to a temporary file next to path, readable by the current user only, and renames it
+// over path. Readers, e.g. kubectl in another terminal, see either the previous contents or the new ones, never a
+// partly written file, and the file never has the umask's permissions, even briefly.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	// TempFile already creates it 0600, the explicit chmod documents it and guards against a change of default
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// lockFile takes the lock kubectl takes before writing a kubeconfig: it creates <path>.lock exclusively and removes
+// it to unlock. Unlike kubectl, which fails straight away, it waits for the lock to be released for lockTimeout.
+func lockFile(path string) (func(), error) {
+	lockPath := path + ".lock"
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(lockPath) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("cannot lock %s: %v", path, err)
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%s is locked by another kubectl-login or kubectl, remove %s if none is running", path, lockPath)
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+// snapshotFile keeps the current contents of path and returns a function restoring them. If the file
+// doesn't exist, restoring removes it.
+func snapshotFile(path string) (func() error, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return func() error {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			return nil
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return func() error { return writeFileAtomic(path, data) }, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir, _ := ioutil.TempDir(os.TempDir(), "atomic")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "kubeconfig_config1")
+	ioutil.WriteFile(path, []byte("previous"), 0644)
+
+	assert.NoError(t, writeFileAtomic(path, []byte("new")))
+
+	data, _ := ioutil.ReadFile(path)
+	assert.Equal(t, "new", string(data))
+	info, _ := os.Stat(path)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), "the permissions of the previous file are not kept")
+	files, _ := ioutil.ReadDir(dir)
+	assert.Len(t, files, 1, "no temporary file is left behind")
+}
+
+func TestLockFile(t *testing.T) {
+	dir, _ := ioutil.TempDir(os.TempDir(), "lock")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "kubeconfig_config1")
+	defer func(original time.Duration) { lockTimeout = original }(lockTimeout)
+	lockTimeout = 200 * time.Millisecond
+
+	unlock, err := lockFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = os.Stat(path + ".lock")
+	assert.NoError(t, err, "kubectl's lock file convention")
+
+	_, err = lockFile(path)
+	assert.Error(t, err, "the lock is held")
+
+	time.AfterFunc(50*time.Millisecond, unlock)
+	unlock, err = lockFile(path)
+	assert.NoError(t, err, "the lock is waited for")
+	unlock()
+	_, err = os.Stat(path + ".lock")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestSnapshotFile(t *testing.T) {
+	dir, _ := ioutil.TempDir(os.TempDir(), "snapshot")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "kubeconfig_config1")
+
+	restore, err := snapshotFile(path)
+	assert.NoError(t, err)
+	ioutil.WriteFile(path, []byte("new"), 0600)
+	assert.NoError(t, restore())
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err), "a file which didn't exist is removed")
+
+	ioutil.WriteFile(path, []byte("previous"), 0600)
+	restore, err = snapshotFile(path)
+	assert.NoError(t, err)
+	ioutil.WriteFile(path, []byte("new"), 0600)
+	assert.NoError(t, restore())
+	data, _ := ioutil.ReadFile(path)
+	assert.Equal(t, "previous", string(data))
+}
+
+func TestApplyLoginRollsBack(t *testing.T) {
+	home, _ := ioutil.TempDir(os.TempDir(), "home")
+	defer os.RemoveAll(home)
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	os.Setenv("HOME", home)
+
+	// the API server rejects the new token, so the login fails after the kubeconfig has been written
+	apiServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer apiServer.Close()
+	masterKubeconfig := filepath.Join(home, "kubeconfig")
+	os.Rename(writeSessionKubeconfig(t, apiServer, ""), masterKubeconfig)
+	clusterKubeconfig := getClusterConfig(masterKubeconfig, "config1")
+	ioutil.WriteFile(clusterKubeconfig, []byte("previous session"), 0600)
+
+	tokens := &cachedTokens{IDToken: signTestToken(t, validTestClaims()), RefreshToken: "refresh"}
+	err := applyLogin(masterKubeconfig, "config1", "alias1", &configuration{}, "secret", tokens)
+	assert.Error(t, err)
+	assert.Equal(t, exitKubectl, exitCodeOf(err))
+
+	data, _ := ioutil.ReadFile(clusterKubeconfig)
+	assert.Equal(t, "previous session", string(data))
+	_, err = os.Stat(clusterKubeconfig + ".lock")
+	assert.True(t, os.IsNotExist(err), "the lock is released")
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/square/go-jose.v2"
+)
+
+// cachedKeySet is an oidc.KeySet that keeps the issuer's signing keys on disk, so tokens can be
+// verified offline. The keys are only fetched again when none of the cached ones signed the token.
+type cachedKeySet struct {
+	issuer string
+	path   string
+}
+
+type jwksCacheEntry struct {
+	JWKSURI string             `json:"jwksUri"`
+	Keys    jose.JSONWebKeySet `json:"keys"`
+}
+
+func newCachedKeySet(issuer string) *cachedKeySet {
+	sum := sha256.Sum256([]byte(issuer))
+	return &cachedKeySet{
+		issuer: issuer,
+		path:   filepath.Join(os.Getenv("HOME"), tokenCacheDir, "jwks", hex.EncodeToString(sum[:8])+".json"),
+	}
+}
+
+func (s *cachedKeySet) VerifySignature(ctx context.Context, jwt string) ([]byte, error) {
+	jws, err := jose.ParseSigned(jwt)
+	if err != nil {
+		return nil, fmt.Errorf("malformed jwt: %v", err)
+	}
+
+	if entry, err := s.load(); err == nil {
+		if payload, err := verifyWithKeys(jws, entry.Keys); err == nil {
+			return payload, nil
+		}
+	}
+
+	entry, err := s.fetch(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("signing keys are not cached and cannot be fetched: %v", err)
+	}
+	if err := s.save(entry); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: cannot cache signing keys of %s: %v\n", s.issuer, err)
+	}
+	return verifyWithKeys(jws, entry.Keys)
+}
+
+func verifyWithKeys(jws *jose.JSONWebSignature, keys jose.JSONWebKeySet) ([]byte, error) {
+	keyID := ""
+	if len(jws.Signatures) > 0 {
+		keyID = jws.Signatures[0].Header.KeyID
+	}
+	for _, key := range keys.Keys {
+		if keyID == "" || key.KeyID == keyID {
+			if payload, err := jws.Verify(&key); err == nil {
+				return payload, nil
+			}
+		}
+	}
+	return nil, errors.New("no known key verifies the signature")
+}
+
+func (s *cachedKeySet) load() (*jwksCacheEntry, error) {
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+	entry := &jwksCacheEntry{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (s *cachedKeySet) save(entry *jwksCacheEntry) error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.path, data, 0600)
+}
+
+func (s *cachedKeySet) fetch(ctx context.Context) (*jwksCacheEntry, error) {
+	var discovery struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := getJSON(ctx, strings.TrimSuffix(s.issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, err
+	}
+	entry := &jwksCacheEntry{JWKSURI: discovery.JWKSURI}
+	if err := getJSON(ctx, discovery.JWKSURI, &entry.Keys); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+
+	"gopkg.in/yaml.v2"
+)
+
+// kubeconfig is the subset of the kubeconfig file format kubectl-login reads and writes.
+// Every struct keeps the fields it doesn't know about in Extra, so a read/modify/write
+// cycle leaves the rest of the file intact.
+type kubeconfig struct {
+	APIVersion     string                 `yaml:"apiVersion,omitempty"`
+	Kind           string                 `yaml:"kind,omitempty"`
+	Clusters       []namedCluster         `yaml:"clusters"`
+	Contexts       []namedContext         `yaml:"contexts"`
+	CurrentContext string                 `yaml:"current-context"`
+	Users          []namedUser            `yaml:"users"`
+	Extra          map[string]interface{} `yaml:",inline"`
+}
+
+type namedCluster struct {
+	Name    string                 `yaml:"name"`
+	Cluster kubeCluster            `yaml:"cluster"`
+	Extra   map[string]interface{} `yaml:",inline"`
+}
+
+type kubeCluster struct {
+	Server                   string                 `yaml:"server,omitempty"`
+	CertificateAuthority     string                 `yaml:"certificate-authority,omitempty"`
+	CertificateAuthorityData string                 `yaml:"certificate-authority-data,omitempty"`
+	InsecureSkipTLSVerify    bool                   `yaml:"insecure-skip-tls-verify,omitempty"`
+	Extra                    map[string]interface{} `yaml:",inline"`
+}
+
+type namedContext struct {
+	Name    string                 `yaml:"name"`
+	Context kubeContext            `yaml:"context"`
+	Extra   map[string]interface{} `yaml:",inline"`
+}
+
+type kubeContext struct {
+	Cluster   string                 `yaml:"cluster"`
+	Namespace string                 `yaml:"namespace,omitempty"`
+	User      string                 `yaml:"user"`
+	Extra     map[string]interface{} `yaml:",inline"`
+}
+
+type namedUser struct {
+	Name  string                 `yaml:"name"`
+	User  kubeUser               `yaml:"user"`
+	Extra map[string]interface{} `yaml:",inline"`
+}
+
+type kubeUser struct {
+	Token        string                 `yaml:"token,omitempty"`
+	AuthProvider *kubeAuthProvider      `yaml:"auth-provider,omitempty"`
+	Exec         *kubeExec              `yaml:"exec,omitempty"`
+	Extra        map[string]interface{} `yaml:",inline"`
+}
+
+type kubeAuthProvider struct {
+	Name   string            `yaml:"name"`
+	Config map[string]string `yaml:"config,omitempty"`
+}
+
+type kubeExec struct {
+	APIVersion      string                 `yaml:"apiVersion"`
+	Command         string                 `yaml:"command"`
+	Args            []string               `yaml:"args,omitempty"`
+	InteractiveMode string                 `yaml:"interactiveMode,omitempty"`
+	Extra           map[string]interface{} `yaml:",inline"`
+}
+
+func loadKubeconfig(path string) (*kubeconfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	k := &kubeconfig{}
+	if err := yaml.Unmarshal(data, k); err != nil {
+		return nil, fmt.Errorf("cannot parse %s: %v", path, err)
+	}
+	return k, nil
+}
+
+// save writes the kubeconfig atomically and readable by the current user only, as it holds tokens and the client secret.
+func (k *kubeconfig) save(path string) error {
+	data, err := yaml.Marshal(k)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// updateKubeconfig loads the kubeconfig at path, applies update and writes it back.
+func updateKubeconfig(path string, update func(k *kubeconfig)) error {
+	k, err := loadKubeconfig(path)
+	if err != nil {
+		return err
+	}
+	update(k)
+	return k.save(path)
+}
+
+// user returns the user entry with the given name, adding an empty one if there is none.
+func (k *kubeconfig) user(name string) *kubeUser {
+	for i := range k.Users {
+		if k.Users[i].Name == name {
+			return &k.Users[i].User
+		}
+	}
+	k.Users = append(k.Users, namedUser{Name: name})
+	return &k.Users[len(k.Users)-1].User
+}
+
+// context returns the context entry with the given name, adding an empty one if there is none.
+func (k *kubeconfig) context(name string) *kubeContext {
+	for i := range k.Contexts {
+		if k.Contexts[i].Name == name {
+			return &k.Contexts[i].Context
+		}
+	}
+	k.Contexts = append(k.Contexts, namedContext{Name: name})
+	return &k.Contexts[len(k.Contexts)-1].Context
+}
+
+// setCluster replaces the entry of the cluster with the same name, or adds it, and reports whether anything changed.
+func (k *kubeconfig) setCluster(cluster namedCluster) bool {
+	for i := range k.Clusters {
+		if k.Clusters[i].Name == cluster.Name {
+			if reflect.DeepEqual(k.Clusters[i], cluster) {
+				return false
+			}
+			k.Clusters[i] = cluster
+			return true
+		}
+	}
+	k.Clusters = append(k.Clusters, cluster)
+	return true
+}
+
+// minify returns a kubeconfig holding only the named cluster, with the certificate-authority file read into
+// certificate-authority-data. A relative certificate-authority is relative to kubeconfigDir, like kubectl resolves it.
+func (k *kubeconfig) minify(cluster, kubeconfigDir string) (*kubeconfig, error) {
+	for _, named := range k.Clusters {
+		if named.Name != cluster {
+			continue
+		}
+		if named.Cluster.CertificateAuthority != "" {
+			path := named.Cluster.CertificateAuthority
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(kubeconfigDir, path)
+			}
+			caData, err := ioutil.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("cannot read certificate-authority of cluster %s: %v", cluster, err)
+			}
+			named.Cluster.CertificateAuthorityData = base64.StdEncoding.EncodeToString(caData)
+			named.Cluster.CertificateAuthority = ""
+		}
+		return &kubeconfig{
+			APIVersion: "v1",
+			Kind:       "Config",
+			Clusters:   []namedCluster{named},
+		}, nil
+	}
+	return nil, fmt.Errorf("no cluster %s", cluster)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+const kubeconfigWithUnknownFields = `
+apiVersion: v1
+kind: Config
+preferences:
+  colors: true
+clusters:
+- cluster:
+    server: https://test-delivery.ft.com
+    certificate-authority-data: Y2EtZGF0YQ==
+    proxy-url: http://proxy.ft.com:3128
+    extensions:
+    - name: client.authentication.k8s.io/exec
+      extension:
+        audience: delivery
+  name: k8s-test-delivery-cluster
+contexts:
+- context:
+    cluster: k8s-test-delivery-cluster
+    user: someone-else
+    extensions: []
+  name: k8s-test-delivery-context
+current-context: k8s-test-delivery-context
+users:
+- name: someone-else
+  user:
+    client-certificate: client.pem
+    client-key: client-key.pem
+`
+
+func writeTempKubeconfig(t *testing.T, contents string) string {
+	file, err := ioutil.TempFile(os.TempDir(), "kubeconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte(contents))
+	file.Close()
+	return file.Name()
+}
+
+func TestUpdateKubeconfigKeepsUnknownFields(t *testing.T) {
+	path := writeTempKubeconfig(t, kubeconfigWithUnknownFields)
+	defer os.Remove(path)
+
+	err := updateKubeconfig(path, func(k *kubeconfig) {
+		k.user(clientID).Token = "token"
+	})
+	assert.NoError(t, err)
+
+	var expected, actual map[string]interface{}
+	yaml.Unmarshal([]byte(kubeconfigWithUnknownFields), &expected)
+	raw, _ := ioutil.ReadFile(path)
+	yaml.Unmarshal(raw, &actual)
+
+	assert.Equal(t, expected["preferences"], actual["preferences"])
+	assert.Equal(t, expected["clusters"], actual["clusters"])
+	assert.Equal(t, expected["contexts"], actual["contexts"])
+	assert.Equal(t, expected["current-context"], actual["current-context"])
+
+	users := actual["users"].([]interface{})
+	assert.Len(t, users, 2)
+	assert.Equal(t, expected["users"].([]interface{})[0], users[0])
+}
+
+func TestKubeconfigUserAndContextLookup(t *testing.T) {
+	k := &kubeconfig{}
+	assert.NoError(t, yaml.Unmarshal([]byte(kubeconfigWithUnknownFields), k))
+
+	existingUser := k.user("someone-else")
+	assert.Equal(t, "client.pem", existingUser.Extra["client-certificate"])
+	assert.Len(t, k.Users, 1)
+
+	newUser := k.user(clientID)
+	newUser.Token = "token"
+	assert.Len(t, k.Users, 2)
+	assert.Equal(t, "token", k.user(clientID).Token)
+
+	existingContext := k.context("k8s-test-delivery-context")
+	assert.Equal(t, "k8s-test-delivery-cluster", existingContext.Cluster)
+	assert.Len(t, k.Contexts, 1)
+
+	k.context("new-context").Cluster = "new-cluster"
+	assert.Len(t, k.Contexts, 2)
+	assert.Equal(t, "new-cluster", k.context("new-context").Cluster)
+}
+
+func TestLoadKubeconfigErrors(t *testing.T) {
+	_, err := loadKubeconfig(os.TempDir() + string(os.PathSeparator) + "does-not-exist")
+	assert.Error(t, err)
+
+	path := writeTempKubeconfig(t, "clusters: [this is: not valid")
+	defer os.Remove(path)
+	_, err = loadKubeconfig(path)
+	assert.Error(t, err)
+}
+
+func TestMinifyKubeconfig(t *testing.T) {
+	dir, _ := ioutil.TempDir(os.TempDir(), "minify")
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "ca.pem"), []byte("ca-data"), 0600)
+
+	k := &kubeconfig{}
+	yaml.Unmarshal([]byte(testKubeconfig), k)
+	minified, err := k.minify("k8s-test-publishing-cluster", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []namedCluster{{
+		Name: "k8s-test-publishing-cluster",
+		Cluster: kubeCluster{
+			Server:                   "https://test-publishing.ft.com",
+			CertificateAuthorityData: "Y2EtZGF0YQ==",
+		},
+	}}, minified.Clusters)
+	assert.Empty(t, minified.Contexts)
+	assert.Empty(t, minified.Users, "the credentials of other clusters are left out")
+	assert.Empty(t, minified.CurrentContext)
+
+	_, err = k.minify("unknown-cluster", dir)
+	assert.Error(t, err)
+	_, err = k.minify("k8s-test-publishing-cluster", filepath.Join(dir, "elsewhere"))
+	assert.Error(t, err, "the certificate authority cannot be read")
+}
+
+func TestMinifyKubeconfigKeepsClusterFields(t *testing.T) {
+	k := &kubeconfig{}
+	yaml.Unmarshal([]byte(kubeconfigWithUnknownFields), k)
+	minified, err := k.minify("k8s-test-delivery-cluster", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "Y2EtZGF0YQ==", minified.Clusters[0].Cluster.CertificateAuthorityData)
+	assert.Equal(t, "http://proxy.ft.com:3128", minified.Clusters[0].Cluster.Extra["proxy-url"])
+	assert.Nil(t, minified.Extra["preferences"])
+}
+
+func TestSwitchConfigMinified(t *testing.T) {
+	dir, _ := ioutil.TempDir(os.TempDir(), "minify")
+	defer os.RemoveAll(dir)
+	masterConfig := filepath.Join(dir, "kubeconfig")
+	ioutil.WriteFile(masterConfig, []byte(kubeconfigWithUnknownFields), 0644)
+
+	clusterConfig, err := switchConfig(masterConfig, "k8s-test-delivery-cluster", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, setIdTokenCreds(clientID, "token", clusterConfig))
+	assert.NoError(t, switchContext("k8s-test-delivery-cluster", "delivery", clientID, "default", clusterConfig))
+
+	k, err := loadKubeconfig(clusterConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, k.Clusters, 1)
+	assert.Equal(t, []namedContext{{Name: "delivery", Context: kubeContext{Cluster: "k8s-test-delivery-cluster", Namespace: "default", User: clientID}}}, k.Contexts)
+	assert.Equal(t, []namedUser{{Name: clientID, User: kubeUser{Token: "token"}}}, k.Users)
+	assert.Equal(t, "delivery", k.CurrentContext)
+
+	_, err = switchConfig(masterConfig, "unknown-cluster", true)
+	assert.Error(t, err)
+	assert.Equal(t, exitKubeconfig, exitCodeOf(err))
+}
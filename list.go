@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+const listCommandName = "list"
+
+type clusterListing struct {
+	Cluster    string   `json:"cluster"`
+	Aliases    []string `json:"aliases"`
+	Issuer     string   `json:"issuer"`
+	Kubeconfig string   `json:"kubeconfig"`
+	LoggedIn   bool     `json:"loggedIn"`
+}
+
+// listCommand implements "kubectl-login list", showing every cluster of the config file and whether it has a valid session.
+func listCommand(args []string) error {
+	flags := flag.NewFlagSet(listCommandName, flag.ExitOnError)
+	output := flags.String("output", outputText, "output format, text or json")
+	aliasesOnly := flags.Bool("aliases", false, "only print the aliases, one per line, without checking the sessions")
+	flags.Parse(args)
+
+	rawConfig, err := getRawConfig()
+	if err != nil {
+		return err
+	}
+	if *aliasesOnly {
+		// used by the shell completion generated by shell-init, which has to be fast
+		return printAliases(os.Stdout, rawConfig)
+	}
+	masterKubeconfig := getMasterConfig(os.Getenv("KUBECONFIG"))
+	listings := listClusters(rawConfig, masterKubeconfig, hasSession)
+
+	switch *output {
+	case outputJSON:
+		err = json.NewEncoder(os.Stdout).Encode(listings)
+	case outputText:
+		err = printClusterListings(os.Stdout, listings)
+	default:
+		return errorf(exitUsage, "unknown output format %s", *output)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot write cluster list: %w", err)
+	}
+	return nil
+}
+
+// listClusters checks the sessions concurrently, as each check may have to reach the cluster.
+func listClusters(rawConfig map[string]*configuration, masterKubeconfig string, loggedIn func(clusterKubeconfig string) bool) []clusterListing {
+	listings := make([]clusterListing, 0, len(rawConfig))
+	for cluster, config := range rawConfig {
+		listings = append(listings, clusterListing{
+			Cluster:    cluster,
+			Aliases:    config.Aliases,
+			Issuer:     config.Issuer,
+			Kubeconfig: getClusterConfig(masterKubeconfig, cluster),
+		})
+	}
+	sort.Slice(listings, func(i, j int) bool { return listings[i].Cluster < listings[j].Cluster })
+
+	var wg sync.WaitGroup
+	for i := range listings {
+		wg.Add(1)
+		go func(listing *clusterListing) {
+			defer wg.Done()
+			listing.LoggedIn = loggedIn(listing.Kubeconfig)
+		}(&listings[i])
+	}
+	wg.Wait()
+	return listings
+}
+
+// hasSession skips the check for clusters which have never been logged in to.
+func hasSession(clusterKubeconfig string) bool {
+	if _, err := os.Stat(clusterKubeconfig); err != nil {
+		return false
+	}
+	state, _ := checkSession(context.Background(), clusterKubeconfig)
+	return state == sessionValid
+}
+
+func printAliases(w io.Writer, rawConfig map[string]*configuration) error {
+	var aliases []string
+	for _, config := range rawConfig {
+		aliases = append(aliases, config.Aliases...)
+	}
+	sort.Strings(aliases)
+	for _, alias := range aliases {
+		if _, err := fmt.Fprintln(w, alias); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func printClusterListings(w io.Writer, listings []clusterListing) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CLUSTER\tALIASES\tISSUER\tSESSION")
+	for _, listing := range listings {
+		session := "-"
+		if listing.LoggedIn {
+			session = "logged in"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", listing.Cluster, strings.Join(listing.Aliases, ","), listing.Issuer, session)
+	}
+	return tw.Flush()
+}
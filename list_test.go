@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListClusters(t *testing.T) {
+	loggedIn := func(clusterKubeconfig string) bool {
+		return strings.HasSuffix(clusterKubeconfig, "_config2")
+	}
+	listings := listClusters(validConfig, "kubeconfig", loggedIn)
+
+	assert.Equal(t, []clusterListing{
+		{
+			Cluster:    "config1",
+			Aliases:    []string{"alias1", "alias2"},
+			Issuer:     "https://upp-k8s-cluster.ft.com",
+			Kubeconfig: "kubeconfig_config1",
+			LoggedIn:   false,
+		},
+		{
+			Cluster:    "config2",
+			Aliases:    []string{"alias3", "alias4"},
+			Issuer:     "https://upp-k8s-cluster2.ft.com",
+			Kubeconfig: "kubeconfig_config2",
+			LoggedIn:   true,
+		},
+	}, listings)
+}
+
+func TestListClustersChecksConcurrently(t *testing.T) {
+	rawConfig := map[string]*configuration{}
+	for _, cluster := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		rawConfig[cluster] = &configuration{}
+	}
+	var inFlight, maxInFlight int32
+	loggedIn := func(string) bool {
+		current := atomic.AddInt32(&inFlight, 1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if current <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, current) {
+				break
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+		atomic.AddInt32(&inFlight, -1)
+		return false
+	}
+
+	listClusters(rawConfig, "kubeconfig", loggedIn)
+	assert.True(t, maxInFlight > 1, "session checks should run concurrently")
+}
+
+func TestHasSessionWithoutKubeconfig(t *testing.T) {
+	assert.False(t, hasSession("does-not-exist_config1"))
+}
+
+func TestPrintClusterListings(t *testing.T) {
+	var out bytes.Buffer
+	err := printClusterListings(&out, []clusterListing{
+		{Cluster: "config1", Aliases: []string{"alias1", "alias2"}, Issuer: "https://issuer1", LoggedIn: true},
+		{Cluster: "config2", Aliases: []string{"alias3"}, Issuer: "https://issuer2"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, `CLUSTER  ALIASES        ISSUER           SESSION
+config1  alias1,alias2  https://issuer1  logged in
+config2  alias3         https://issuer2  -
+`, out.String())
+}
+
+func TestPrintAliases(t *testing.T) {
+	var out bytes.Buffer
+	assert.NoError(t, printAliases(&out, validConfig))
+	assert.Equal(t, "alias1\nalias2\nalias3\nalias4\n", out.String())
+}
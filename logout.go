@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/coreos/go-oidc"
+)
+
+const logoutCommandName = "logout"
+
+// logoutCommand implements "kubectl-login logout <alias|--all>". It prints the master kubeconfig
+// path, so the wrapper scripts can point KUBECONFIG back at it.
+func logoutCommand(args []string) error {
+	flags := flag.NewFlagSet(logoutCommandName, flag.ExitOnError)
+	all := flags.Bool("all", false, "log out of every cluster in the config file")
+	args = parseInterleaved(flags, args)
+
+	rawConfig, err := getRawConfig()
+	if err != nil {
+		return err
+	}
+	clusters := rawConfig
+	if !*all {
+		alias, err := getAlias(args)
+		if err != nil {
+			return err
+		}
+		config, cluster, err := getConfigByAlias(alias, rawConfig)
+		if err != nil {
+			return err
+		}
+		clusters = map[string]*configuration{cluster: config}
+	}
+
+	masterKubeconfig, err := requireMasterConfig()
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(clusters))
+	for cluster := range clusters {
+		names = append(names, cluster)
+	}
+	sort.Strings(names)
+	for _, cluster := range names {
+		if err := logout(context.Background(), clusters[cluster], cluster, getClusterConfig(masterKubeconfig, cluster)); err != nil {
+			return err
+		}
+	}
+	// the wrappers set KUBECONFIG back to the master kubeconfig
+	fmt.Fprintln(os.Stdout, masterKubeconfig)
+	return nil
+}
+
+// logout deletes the per-cluster kubeconfig and cached tokens of the cluster, then revokes the refresh token of its
+// session if the issuer supports it. Tokens shared with other clusters are only revoked by the last of them.
+func logout(ctx context.Context, config *configuration, cluster, clusterKubeconfig string) error {
+	tokens := loadPreviousTokens(cluster, config.getUserName(cluster), clusterKubeconfig)
+
+	unlock, err := lockFile(clusterKubeconfig)
+	if err != nil {
+		return errorf(exitKubeconfig, "%w", err)
+	}
+	defer unlock()
+	paths := []string{clusterKubeconfig, tokenCachePath(cluster)}
+	if tokens != nil && tokens.Shared != "" {
+		if sharedTokensInUse(tokens.Shared, cluster) {
+			fmt.Fprintf(os.Stderr, "Not revoking the refresh token of %s, other clusters still share it\n", cluster)
+			tokens = nil
+		} else {
+			paths = append(paths, sharedTokensPath(tokens.Shared))
+		}
+	}
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return errorf(exitKubeconfig, "cannot remove %s: %w", path, err)
+		}
+	}
+
+	if tokens != nil && tokens.RefreshToken != "" {
+		if err := revokeRefreshToken(ctx, config, tokens.RefreshToken); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: cannot revoke the refresh token of %s: %v\n", cluster, err)
+		}
+	}
+	return nil
+}
+
+// revokeRefreshToken revokes the token at the issuer's RFC 7009 revocation endpoint.
+// Issuers which don't advertise one are left alone, the token then stays valid until it expires.
+func revokeRefreshToken(ctx context.Context, config *configuration, refreshToken string) error {
+	provider, err := oidc.NewProvider(ctx, config.Issuer)
+	if err != nil {
+		return err
+	}
+	var claims struct {
+		RevocationEndpoint string `json:"revocation_endpoint"`
+	}
+	if err := provider.Claims(&claims); err != nil {
+		return err
+	}
+	if claims.RevocationEndpoint == "" {
+		return nil
+	}
+
+	form := url.Values{"token": {refreshToken}, "token_type_hint": {"refresh_token"}}
+	clientSecret, err := getKubeLogin(config)
+	if err != nil {
+		return err
+	}
+	if clientSecret == "" {
+		form.Set("client_id", config.getClientID())
+	}
+	req, err := http.NewRequest(http.MethodPost, claims.RevocationEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if clientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(config.getClientID()), url.QueryEscape(clientSecret))
+	}
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("revocation endpoint returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newRevocationTestIssuer(t *testing.T, advertiseRevocation bool, revoked *[]string) *httptest.Server {
+	handlers := map[string]testIssuerHandler{
+		"/revoke": func(w http.ResponseWriter, r *http.Request, issuer string) {
+			user, password, ok := r.BasicAuth()
+			if !ok || user != clientID || password != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			r.ParseForm()
+			assert.Equal(t, "refresh_token", r.Form.Get("token_type_hint"))
+			*revoked = append(*revoked, r.Form.Get("token"))
+		},
+	}
+	if advertiseRevocation {
+		handlers["/.well-known/openid-configuration"] = func(w http.ResponseWriter, r *http.Request, issuer string) {
+			discovery := testDiscovery(issuer)
+			discovery["revocation_endpoint"] = issuer + "/revoke"
+			json.NewEncoder(w).Encode(discovery)
+		}
+	}
+	return newTestIssuer(t, nil, handlers)
+}
+
+func TestRevokeRefreshToken(t *testing.T) {
+	var revoked []string
+	server := newRevocationTestIssuer(t, true, &revoked)
+	defer server.Close()
+
+	err := revokeRefreshToken(context.Background(), &configuration{Issuer: server.URL, LoginSecret: "secret"}, "refresh")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"refresh"}, revoked)
+
+	err = revokeRefreshToken(context.Background(), &configuration{Issuer: server.URL, LoginSecret: "wrong"}, "refresh")
+	assert.Error(t, err)
+}
+
+func TestRevokeRefreshTokenNotAdvertised(t *testing.T) {
+	var revoked []string
+	server := newRevocationTestIssuer(t, false, &revoked)
+	defer server.Close()
+
+	err := revokeRefreshToken(context.Background(), &configuration{Issuer: server.URL, LoginSecret: "secret"}, "refresh")
+	assert.NoError(t, err)
+	assert.Empty(t, revoked)
+}
+
+func TestLogout(t *testing.T) {
+	home, _ := ioutil.TempDir(os.TempDir(), "home")
+	defer os.RemoveAll(home)
+	originalHome := os.Getenv("HOME")
+	defer os.Setenv("HOME", originalHome)
+	os.Setenv("HOME", home)
+
+	var revoked []string
+	server := newRevocationTestIssuer(t, true, &revoked)
+	defer server.Close()
+
+	path := writeTempKubeconfig(t, testKubeconfig)
+	defer os.Remove(path)
+	setOIDCAuth(clientID, clientID, "secret", "id-token", "kubeconfig-refresh", server.URL, path)
+	saveCachedTokens("config1", &cachedTokens{IDToken: "id-token", RefreshToken: "cached-refresh"})
+
+	logout(context.Background(), &configuration{Issuer: server.URL, LoginSecret: "secret"}, "config1", path)
+
+	assert.Equal(t, []string{"kubeconfig-refresh"}, revoked, "the token kubectl rotated into the kubeconfig is the live one")
+	_, err := os.Stat(path)
+	assert.True(t, os.IsNotExist(err), "the per-cluster kubeconfig should be deleted")
+	_, err = os.Stat(tokenCachePath("config1"))
+	assert.True(t, os.IsNotExist(err), "the cached tokens should be deleted")
+
+	// logging out twice is harmless
+	logout(context.Background(), &configuration{Issuer: server.URL, LoginSecret: "secret"}, "config1", path)
+	assert.Len(t, revoked, 1)
+}
+
+func TestLogoutCommandPrintsMasterKubeconfig(t *testing.T) {
+	home, _ := ioutil.TempDir(os.TempDir(), "home")
+	defer os.RemoveAll(home)
+	marshaledConfig, _ := json.Marshal(validConfig)
+	ioutil.WriteFile(filepath.Join(home, configFile), marshaledConfig, 0644)
+
+	originalHome, originalKubeconfig, originalStdout := os.Getenv("HOME"), os.Getenv("KUBECONFIG"), os.Stdout
+	defer func() {
+		os.Setenv("HOME", originalHome)
+		os.Setenv("KUBECONFIG", originalKubeconfig)
+		os.Stdout = originalStdout
+	}()
+	os.Setenv("HOME", home)
+	// a path which would be mangled if it was used as a format string
+	masterKubeconfig := filepath.Join(home, "100%done")
+	os.Setenv("KUBECONFIG", getClusterConfig(masterKubeconfig, "config1"))
+
+	stdout, _ := os.Create(filepath.Join(home, "stdout"))
+	os.Stdout = stdout
+	assert.NoError(t, logoutCommand([]string{"alias1"}))
+	stdout.Close()
+	output, _ := ioutil.ReadFile(stdout.Name())
+
+	assert.Equal(t, masterKubeconfig+"\n", string(output))
+}
+
+func TestLogoutCommandWithoutKubeconfig(t *testing.T) {
+	home, _ := ioutil.TempDir(os.TempDir(), "home")
+	defer os.RemoveAll(home)
+	marshaledConfig, _ := json.Marshal(validConfig)
+	ioutil.WriteFile(filepath.Join(home, configFile), marshaledConfig, 0644)
+
+	originalHome, originalKubeconfig := os.Getenv("HOME"), os.Getenv("KUBECONFIG")
+	originalDir, _ := os.Getwd()
+	defer func() {
+		os.Setenv("HOME", originalHome)
+		os.Setenv("KUBECONFIG", originalKubeconfig)
+		os.Chdir(originalDir)
+	}()
+	os.Setenv("HOME", home)
+	os.Unsetenv("KUBECONFIG")
+	os.Chdir(home)
+	// a per-cluster kubeconfig relative to the working directory, which logout must leave alone
+	ioutil.WriteFile("_config1", []byte(testKubeconfig), 0600)
+
+	err := logoutCommand([]string{"alias1"})
+	assert.Error(t, err)
+	assert.Equal(t, exitKubeconfig, exitCodeOf(err))
+	assert.FileExists(t, filepath.Join(home, "_config1"))
+	assert.NoFileExists(t, filepath.Join(home, "_config1.lock"))
+}
+
+func TestLogoutSharedTokens(t *testing.T) {
+	home, _ := ioutil.TempDir(os.TempDir(), "home")
+	defer os.RemoveAll(home)
+	originalHome := os.Getenv("HOME")
+	defer os.Setenv("HOME", originalHome)
+	os.Setenv("HOME", home)
+
+	var revoked []string
+	server := newRevocationTestIssuer(t, true, &revoked)
+	defer server.Close()
+	config := &configuration{Issuer: server.URL, LoginSecret: "secret"}
+	name := sharedTokensName(server.URL, clientID)
+	for _, cluster := range []string{"config1", "config2"} {
+		saveCachedTokens(cluster, &cachedTokens{IDToken: "id-token", RefreshToken: "shared-refresh", Shared: name})
+	}
+
+	logout(context.Background(), config, "config1", filepath.Join(home, "kubeconfig_config1"))
+	assert.Empty(t, revoked, "config2 still uses the refresh token")
+	_, err := loadCachedTokens("config2")
+	assert.NoError(t, err)
+
+	logout(context.Background(), config, "config2", filepath.Join(home, "kubeconfig_config2"))
+	assert.Equal(t, []string{"shared-refresh"}, revoked)
+	_, err = os.Stat(sharedTokensPath(name))
+	assert.True(t, os.IsNotExist(err), "the shared tokens should be deleted")
+}
@@ -2,15 +2,22 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"flag"
 	"fmt"
-	"io"
 	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/coreos/go-oidc"
 	"golang.org/x/oauth2"
+	"gopkg.in/yaml.v2"
 
 	"encoding/json"
 	"io/ioutil"
@@ -20,168 +27,684 @@ import (
 	. "github.com/logrusorgru/aurora"
 )
 
-var logger = log.New(os.Stdout, "", log.LUTC)
+// logger is for warnings. stdout is reserved for the results the wrappers and kubectl parse, e.g. the kubeconfig
+// path, which are written to it explicitly.
+var logger = log.New(os.Stderr, "", log.LUTC)
+
+// startCommand starts every subprocess of kubectl-login. Their command lines can be read by any user of the
+// machine, so tokens and secrets must never be passed as arguments: credentials are written in-process instead.
+var startCommand = exec.Command
 
 const (
 	clientID        = "kubectl-login"
 	configFile      = ".kubectl-login.json"
-	state           = "csrf-protection-state"
+	configPathEnv   = "KUBECTL_LOGIN_CONFIG"
 	oidcProvider    = "oidc"
 	tokensSeparator = ";"
 )
 
 type configuration struct {
-	Issuer      string   `json:"issuer"`
-	RedirectURL string   `json:"redirectUrl"`
-	LoginSecret string   `json:"loginSecret"`
-	Aliases     []string `json:"aliases"`
+	Issuer      string   `json:"issuer" yaml:"issuer"`
+	RedirectURL string   `json:"redirectUrl" yaml:"redirectUrl"`
+	LoginSecret string   `json:"loginSecret" yaml:"loginSecret"`
+	Aliases     []string `json:"aliases" yaml:"aliases"`
+	// Loopback receives the authorization code on 127.0.0.1 instead of asking for the tokens to be pasted.
+	// The Dex client must have http://127.0.0.1:<loopbackPort>/callback registered as a redirect URI.
+	Loopback     bool `json:"loopback" yaml:"loopback"`
+	LoopbackPort int  `json:"loopbackPort" yaml:"loopbackPort"`
+	// PKCE adds an S256 code challenge to the authorization request. Clusters whose Dex client is public
+	// don't need a loginSecret when it is enabled. It requires loopback, as dex-redirect does its own exchange.
+	PKCE bool `json:"pkce" yaml:"pkce"`
+	// DeviceCode logs in with the OAuth 2.0 device authorization grant, for machines without a browser.
+	DeviceCode bool `json:"deviceCode" yaml:"deviceCode"`
+	// ExecCredential writes a client.authentication.k8s.io/v1 exec plugin calling "kubectl-login get-token"
+	// instead of the legacy oidc auth-provider, which current kubectl releases no longer support.
+	ExecCredential bool `json:"execCredential" yaml:"execCredential"`
+	// ClientID and Scopes override the default Dex client and scopes. AuthParams are added to the
+	// authorization request, e.g. connector_id to skip Dex's connector selection, prompt or login_hint.
+	ClientID   string            `json:"clientId" yaml:"clientId"`
+	Scopes     []string          `json:"scopes,omitempty" yaml:"scopes,omitempty"`
+	AuthParams map[string]string `json:"authParams,omitempty" yaml:"authParams,omitempty"`
+	// Audience is the client ID the API server expects in the id token, its --oidc-client-id, when it isn't ClientID.
+	// The token is then requested for it with a Dex cross-client scope.
+	Audience string `json:"audience" yaml:"audience"`
+	// Namespace, ContextName and UserName set up the context written to the per-cluster kubeconfig.
+	// UserPerCluster names the user after the cluster, so merged kubeconfigs don't share one kubectl-login user.
+	Namespace      string `json:"namespace" yaml:"namespace"`
+	ContextName    string `json:"contextName" yaml:"contextName"`
+	UserName       string `json:"userName" yaml:"userName"`
+	UserPerCluster bool   `json:"userPerCluster" yaml:"userPerCluster"`
+	// MinifyKubeconfig writes only the cluster, its user and its context to the per-cluster kubeconfig
+	// rather than a full copy of the master kubeconfig.
+	MinifyKubeconfig bool `json:"minifyKubeconfig" yaml:"minifyKubeconfig"`
+	// The client secret can be read from an environment variable, a file, a command or the system keyring
+	// rather than kept in plaintext in loginSecret. See secret.go.
+	LoginSecretEnv     string            `json:"loginSecretEnv" yaml:"loginSecretEnv"`
+	LoginSecretFile    string            `json:"loginSecretFile" yaml:"loginSecretFile"`
+	LoginSecretCommand string            `json:"loginSecretCommand" yaml:"loginSecretCommand"`
+	LoginSecretKeyring map[string]string `json:"loginSecretKeyring,omitempty" yaml:"loginSecretKeyring,omitempty"`
+}
+
+func (c *configuration) getNamespace() string {
+	if c.Namespace != "" {
+		return c.Namespace
+	}
+	return "default"
+}
+
+func (c *configuration) getContextName(cluster string) string {
+	if c.ContextName != "" {
+		return c.ContextName
+	}
+	return cluster
+}
+
+// getUserName defaults to "kubectl-login", the name every cluster's user had before it was configurable.
+func (c *configuration) getUserName(cluster string) string {
+	switch {
+	case c.UserName != "":
+		return c.UserName
+	case c.UserPerCluster:
+		return cluster
+	default:
+		return clientID
+	}
+}
+
+func (c *configuration) getClientID() string {
+	if c.ClientID != "" {
+		return c.ClientID
+	}
+	return clientID
+}
+
+func (c *configuration) getAudience() string {
+	if c.Audience != "" {
+		return c.Audience
+	}
+	return c.getClientID()
+}
+
+// getScopes always includes "openid", which is required for OpenID Connect flows,
+// and the cross-client scopes of the audience.
+func (c *configuration) getScopes() []string {
+	return append(c.getBaseScopes(), crossClientScopes(c.getClientID(), []string{c.getAudience()})...)
+}
+
+func (c *configuration) getBaseScopes() []string {
+	if len(c.Scopes) == 0 {
+		return []string{oidc.ScopeOpenID, "profile", "email", "groups", "offline_access"}
+	}
+	for _, scope := range c.Scopes {
+		if scope == oidc.ScopeOpenID {
+			return c.Scopes
+		}
+	}
+	return append([]string{oidc.ScopeOpenID}, c.Scopes...)
+}
+
+// getAuthCodeOptions returns the extra authorization request parameters, sorted so the URL is stable.
+func (c *configuration) getAuthCodeOptions() []oauth2.AuthCodeOption {
+	keys := make([]string, 0, len(c.AuthParams))
+	for key := range c.AuthParams {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	opts := make([]oauth2.AuthCodeOption, 0, len(keys))
+	for _, key := range keys {
+		opts = append(opts, oauth2.SetAuthURLParam(key, c.AuthParams[key]))
+	}
+	return opts
+}
+
+type options struct {
+	deviceCode bool
+	output     string
+	// all and issuer log in to several clusters at once, see sso.go
+	all    bool
+	issuer string
 }
 
 func main() {
-	rawConfig := getRawConfig()
-	alias := getAlias(os.Args[1:])
-	config, cluster := getConfigByAlias(alias, rawConfig)
+	if err := run(os.Args[1:]); err != nil {
+		// stdout is left to the output the wrappers parse
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(int(exitCodeOf(err)))
+	}
+}
+
+func run(args []string) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case getTokenCommandName:
+			return getTokenCommand(args[1:])
+		case statusCommandName, whoamiCommandName:
+			return statusCommand(args[1:])
+		case logoutCommandName:
+			return logoutCommand(args[1:])
+		case listCommandName:
+			return listCommand(args[1:])
+		case configCommandName:
+			return configCommand(args[1:])
+		case shellInitCommandName:
+			return shellInitCommand(args[1:])
+		case loginCommandName:
+			return login(args[1:])
+		case syncCommandName:
+			return syncCommand(args[1:])
+		}
+	}
+	return login(args)
+}
 
-	currentKubeconfig := os.Getenv("KUBECONFIG")
-	masterKubeconfig := currentKubeconfig
-	if !isMasterConfig(currentKubeconfig) {
-		masterKubeconfig = strings.Split(currentKubeconfig, "_")[0]
+func login(cmdArgs []string) error {
+	opts, args := parseFlags(cmdArgs)
+	if !validLoginOutput(opts.output) {
+		return errorf(exitUsage, "unknown output format %s", opts.output)
+	}
+	if opts.all || opts.issuer != "" {
+		return loginSingleSignOn(opts, args)
+	}
+
+	result := &loginResult{}
+	err := loginCluster(opts, args, result)
+	if writeErr := writeLoginResult(os.Stdout, opts.output, result); writeErr != nil && err == nil {
+		err = fmt.Errorf("cannot write login result: %w", writeErr)
+	}
+	return err
+}
+
+// loginCluster fills in result as it goes, so that a failed login still reports what it got to.
+func loginCluster(opts *options, args []string, result *loginResult) error {
+	rawConfig, err := getRawConfig()
+	if err != nil {
+		return err
+	}
+	masterKubeconfig := getMasterConfig(os.Getenv("KUBECONFIG"))
+	var alias string
+	if len(args) == 0 && canPick() {
+		alias, err = pickAlias(rawConfig, masterKubeconfig)
+	} else {
+		alias, err = getAlias(args)
+	}
+	if err != nil {
+		return err
+	}
+	config, cluster, err := getConfigByAlias(alias, rawConfig)
+	if err != nil {
+		return err
 	}
 
 	newKubeconfig := getClusterConfig(masterKubeconfig, cluster)
-	if isLoggedIn(newKubeconfig) {
-		logger.Printf(newKubeconfig)
-		os.Exit(0)
+	result.Cluster, result.Kubeconfig = cluster, newKubeconfig
+	syncBeforeLogin(masterKubeconfig, cluster, config)
+	if isLoggedIn(cluster, newKubeconfig) {
+		result.LoggedIn = true
+		return nil
+	}
+
+	if config.PKCE && !config.Loopback {
+		return errorf(exitConfig, "pkce is enabled for %s but requires loopback to be enabled too", cluster)
+	}
+
+	// read before the per-cluster kubeconfig is replaced, as it may hold the refresh token of the last login
+	previousTokens := loadPreviousTokens(cluster, config.getUserName(cluster), newKubeconfig)
+
+	kubeLogin, err := getKubeLogin(config)
+	if err != nil {
+		return err
+	}
+	prompt := &loginPrompt{out: os.Stderr}
+	tokens, err := obtainTokens(context.Background(), opts, cluster, config, kubeLogin, previousTokens, prompt)
+	result.LoginURL = prompt.url
+	if err != nil {
+		return err
+	}
+
+	if err := applyLogin(masterKubeconfig, cluster, alias, config, kubeLogin, tokens); err != nil {
+		return err
+	}
+	result.LoggedIn = true
+	return nil
+}
+
+// obtainTokens refreshes the previous session if there is one and falls back to an interactive login.
+// name is only used in messages, it is the cluster or, for a single sign-on, the issuer.
+func obtainTokens(ctx context.Context, opts *options, name string, config *configuration, kubeLogin string, previousTokens *cachedTokens, prompt *loginPrompt) (*cachedTokens, error) {
+	tokens, err := silentRefresh(ctx, config, kubeLogin, previousTokens)
+	if err == nil {
+		return tokens, nil
+	}
+	if previousTokens != nil {
+		fmt.Fprintf(os.Stderr, "Cannot refresh the previous session of %s, logging in again: %v\n", name, err)
+	}
+	rawIdToken, refreshToken, err := interactiveLogin(ctx, opts, config, kubeLogin, prompt)
+	if err != nil {
+		return nil, err
 	}
+	return &cachedTokens{IDToken: rawIdToken, RefreshToken: refreshToken}, nil
+}
 
-	switchConfig(masterKubeconfig, cluster)
-	kubeLogin := getKubeLogin(config)
-	ctx := context.Background()
+// applyLogin caches the tokens of the cluster and writes them into a fresh copy of the master kubeconfig.
+// The per-cluster kubeconfig is locked meanwhile, and restored if any step fails.
+func applyLogin(masterKubeconfig, cluster, alias string, config *configuration, kubeLogin string, tokens *cachedTokens) error {
+	if err := saveCachedTokens(cluster, tokens); err != nil {
+		if config.ExecCredential {
+			return errorf(exitKubeconfig, "cannot cache tokens: %w", err)
+		}
+		fmt.Fprintf(os.Stderr, "warning: cannot cache tokens for %s: %v\n", cluster, err)
+	}
 
+	clusterKubeconfig := getClusterConfig(masterKubeconfig, cluster)
+	unlock, err := lockFile(clusterKubeconfig)
+	if err != nil {
+		return errorf(exitKubeconfig, "%w", err)
+	}
+	defer unlock()
+	restore, err := snapshotFile(clusterKubeconfig)
+	if err != nil {
+		return errorf(exitKubeconfig, "cannot read kubeconfig %s: %w", clusterKubeconfig, err)
+	}
+
+	if err := writeLogin(masterKubeconfig, cluster, alias, config, kubeLogin, tokens); err != nil {
+		if restoreErr := restore(); restoreErr != nil {
+			fmt.Fprintf(os.Stderr, "warning: cannot restore %s: %v\n", clusterKubeconfig, restoreErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// writeLogin replaces the per-cluster kubeconfig with a copy of the master one holding the credentials and
+// checks that the API server accepts them.
+func writeLogin(masterKubeconfig, cluster, alias string, config *configuration, kubeLogin string, tokens *cachedTokens) error {
+	newKubeconfig, err := switchConfig(masterKubeconfig, cluster, config.MinifyKubeconfig)
+	if err != nil {
+		return err
+	}
+	userName := config.getUserName(cluster)
+	if config.ExecCredential {
+		err = setExecCredential(userName, alias, newKubeconfig)
+	} else if len(tokens.RefreshToken) == 0 || tokens.Shared != "" {
+		// kubectl would refresh shared tokens in this kubeconfig only, spending the refresh token of the other clusters
+		err = setIdTokenCreds(userName, tokens.IDToken, newKubeconfig)
+	} else {
+		err = setOIDCAuth(userName, config.getClientID(), kubeLogin, tokens.IDToken, tokens.RefreshToken, config.Issuer, newKubeconfig)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := switchContext(cluster, config.getContextName(cluster), userName, config.getNamespace(), newKubeconfig); err != nil {
+		return err
+	}
+	switch state, err := checkSession(context.Background(), newKubeconfig); state {
+	case sessionValid:
+	case sessionUnreachable:
+		fmt.Fprintf(os.Stderr, "warning: cannot check the new session of %s: %v\n", cluster, err)
+	default:
+		return errorf(exitKubectl, "the session of %s doesn't work, even after login: %w", cluster, err)
+	}
+	return nil
+}
+
+// silentRefresh redeems the refresh token of the previous login, so no browser round-trip is needed while it is valid.
+func silentRefresh(ctx context.Context, config *configuration, clientSecret string, previousTokens *cachedTokens) (*cachedTokens, error) {
+	if previousTokens == nil {
+		return nil, errors.New("no previous session")
+	}
+	return refreshCachedTokens(ctx, config, clientSecret, previousTokens)
+}
+
+// interactiveLogin asks the user to authenticate with the issuer and returns the verified id token and the refresh token.
+func interactiveLogin(ctx context.Context, opts *options, config *configuration, kubeLogin string, prompt *loginPrompt) (string, string, error) {
 	// Initialize a provider by specifying dex's issuer URL.
 	provider, err := oidc.NewProvider(ctx, config.Issuer)
 	if err != nil {
-		logger.Fatalf("error: cannot initialize OIDC provider for issuer %s:%v", config.Issuer, err)
+		return "", "", errorf(exitNetwork, "cannot initialize OIDC provider for issuer %s: %w", config.Issuer, err)
 	}
 
-	// Configure the OAuth2 config with the client values.
-	oauth2Config := oauth2.Config{
-		ClientID:     clientID,
-		ClientSecret: kubeLogin,
+	oauth2Config := newOAuth2Config(config, provider.Endpoint(), kubeLogin)
+
+	// state protects the redirect against CSRF and nonce binds the id token to this login, so neither can be replayed.
+	// The device code grant has no redirect, so the nonce is only checked for the browser flows.
+	loginState := newRandomToken()
+	var nonce string
+
+	authParams := config.getAuthCodeOptions()
+
+	var rawIdToken, refreshToken string
+	switch {
+	case opts.deviceCode || config.DeviceCode:
+		rawIdToken, refreshToken, err = loginWithDeviceCode(ctx, provider, oauth2Config, authParams, prompt)
+	case config.Loopback:
+		var pkceVerifier string
+		if config.PKCE {
+			pkceVerifier = oauth2.GenerateVerifier()
+		}
+		nonce = newRandomToken()
+		rawIdToken, refreshToken, err = loginWithCallback(ctx, oauth2Config, authParams, config.LoopbackPort, loginState, nonce, pkceVerifier, prompt)
+	default:
+		nonce = newRandomToken()
+		rawIdToken, refreshToken, err = loginWithPaste(oauth2Config, authParams, loginState, nonce, prompt)
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	idTokenVerifier := provider.Verifier(&oidc.Config{ClientID: config.getClientID()})
+	if err = verifyIdToken(ctx, idTokenVerifier, rawIdToken, nonce); err != nil {
+		return "", "", errorf(exitAuth, "token is invalid: %w", err)
+	}
+	return rawIdToken, refreshToken, nil
+}
+
+// newOAuth2Config configures the OAuth2 client of the given cluster against the endpoints returned by discovery.
+func newOAuth2Config(config *configuration, endpoint oauth2.Endpoint, clientSecret string) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     config.getClientID(),
+		ClientSecret: clientSecret,
 		RedirectURL:  config.RedirectURL,
-		Endpoint:     provider.Endpoint(),                                                        // Discovery returns the OAuth2 endpoints.
-		Scopes:       []string{oidc.ScopeOpenID, "profile", "email", "groups", "offline_access"}, // "openid" is a required scope for OpenID Connect flows.
+		Endpoint:     endpoint,
+		Scopes:       config.getScopes(),
+	}
+}
+
+// loginWithPaste sends the user to dex-redirect, which shows the tokens to be pasted back into the terminal.
+// Newer dex-redirect versions append the state to the pasted tokens, in which case it has to match.
+func loginWithPaste(oauth2Config *oauth2.Config, authParams []oauth2.AuthCodeOption, loginState, nonce string, prompt *loginPrompt) (string, string, error) {
+	redirectUrl := oauth2Config.AuthCodeURL(loginState, append(authParams, oidc.Nonce(nonce))...)
+	if err := prompt.open(redirectUrl); err != nil {
+		return "", "", err
+	}
+
+	tokensInput, err := readTokens()
+	if err != nil {
+		return "", "", errorf(exitAuth, "cannot read token from terminal: %w", err)
+	}
+	rawIdToken, refreshToken, pastedState := extractTokens(tokensInput)
+	if pastedState != "" && pastedState != loginState {
+		return "", "", errorf(exitAuth, "the pasted tokens belong to a different login attempt")
 	}
+	return rawIdToken, refreshToken, nil
+}
 
-	redirectUrl := oauth2Config.AuthCodeURL(state)
-	logger.Println(redirectUrl)
-	browserErr := openBrowser(redirectUrl)
-	
-	if browserErr != nil {
-    	if !strings.Contains(browserErr.Error(), "executable file not found in $PATH") {
-        	logger.Fatalf("error: cannot open browser: %v", browserErr)
-        }
+// loginWithCallback receives the authorization code on a loopback listener and exchanges it for tokens itself.
+// When pkceVerifier is set, its S256 challenge is sent with the authorization request and the verifier with the exchange.
+func loginWithCallback(ctx context.Context, oauth2Config *oauth2.Config, authParams []oauth2.AuthCodeOption, port int, loginState, nonce, pkceVerifier string, prompt *loginPrompt) (string, string, error) {
+	server, err := newCallbackServer(port, loginState)
+	if err != nil {
+		return "", "", fmt.Errorf("cannot start login callback server: %w", err)
 	}
+	defer server.close()
 
-	idTokenVerifier := provider.Verifier(&oidc.Config{ClientID: clientID})
-	tokensInput := readTokens()
-	rawIdToken, refreshToken := extractTokens(tokensInput)
-	if _, err = idTokenVerifier.Verify(ctx, rawIdToken); err != nil {
-		logger.Fatalf("error: token is invalid: %v", err)
+	authOpts := append(authParams, oidc.Nonce(nonce))
+	var exchangeOpts []oauth2.AuthCodeOption
+	if pkceVerifier != "" {
+		authOpts = append(authOpts, oauth2.S256ChallengeOption(pkceVerifier))
+		exchangeOpts = append(exchangeOpts, oauth2.VerifierOption(pkceVerifier))
 	}
 
-	if len(refreshToken) == 0 {
-		setIdTokenCreds(rawIdToken, newKubeconfig)
-	} else {
-		setOIDCAuth(kubeLogin, rawIdToken, refreshToken, config.Issuer, newKubeconfig)
+	oauth2Config.RedirectURL = server.redirectURL()
+	redirectUrl := oauth2Config.AuthCodeURL(loginState, authOpts...)
+	if err := prompt.open(redirectUrl); err != nil {
+		return "", "", err
 	}
 
-	switchContext(cluster, newKubeconfig)
-	if !isLoggedIn(newKubeconfig) {
-		logger.Fatal("error: kubectl command didn't work, even after login!")
+	waitCtx, cancel := context.WithTimeout(ctx, loginTimeout)
+	defer cancel()
+	code, err := server.waitForCode(waitCtx)
+	if err != nil {
+		return "", "", errorf(exitAuth, "login callback failed: %w", err)
+	}
+
+	token, err := oauth2Config.Exchange(ctx, code, exchangeOpts...)
+	if err != nil {
+		return "", "", errorf(exitAuth, "cannot exchange authorization code for tokens: %w", err)
+	}
+	rawIdToken, refreshToken, err := tokensFromResponse(token)
+	if err != nil {
+		return "", "", errorf(exitAuth, "%w", err)
+	}
+	return rawIdToken, refreshToken, nil
+}
+
+// tokensFromResponse returns the id token and the (possibly empty) refresh token of a token endpoint response.
+func tokensFromResponse(token *oauth2.Token) (string, string, error) {
+	rawIdToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIdToken == "" {
+		return "", "", errors.New("no id_token in token response")
+	}
+	return rawIdToken, token.RefreshToken, nil
+}
+
+// verifyIdToken checks the token signature, issuer, audience and expiry and, when a nonce was sent, that the token carries it.
+func verifyIdToken(ctx context.Context, verifier *oidc.IDTokenVerifier, rawIdToken, nonce string) error {
+	idToken, err := verifier.Verify(ctx, rawIdToken)
+	if err != nil {
+		return err
+	}
+	if nonce != "" && idToken.Nonce != nonce {
+		return errors.New("nonce does not match the login request")
+	}
+	return nil
+}
+
+// decodeIdTokenClaims unmarshals the payload of a jwt without verifying its signature.
+func decodeIdTokenClaims(rawIdToken string, v interface{}) error {
+	parts := strings.Split(rawIdToken, ".")
+	if len(parts) != 3 {
+		return errors.New("malformed jwt")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return fmt.Errorf("malformed jwt payload: %v", err)
+	}
+	if err := json.Unmarshal(payload, v); err != nil {
+		return fmt.Errorf("malformed jwt claims: %v", err)
+	}
+	return nil
+}
+
+// idTokenExpiry reads the exp claim without verifying the signature. It is only meant for tokens
+// kubectl-login stored itself, to decide whether they have to be refreshed.
+func idTokenExpiry(rawIdToken string) (time.Time, error) {
+	var claims struct {
+		Expiry int64 `json:"exp"`
+	}
+	if err := decodeIdTokenClaims(rawIdToken, &claims); err != nil {
+		return time.Time{}, err
+	}
+	if claims.Expiry == 0 {
+		return time.Time{}, errors.New("no exp claim in jwt")
+	}
+	return time.Unix(claims.Expiry, 0), nil
+}
+
+func newRandomToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		// only happens when the OS has no source of randomness, nothing can be done about it
+		panic(fmt.Sprintf("cannot generate random value: %v", err))
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func launchBrowser(url string) error {
+	if err := openBrowser(url); err != nil {
+		if !strings.Contains(err.Error(), "executable file not found in $PATH") {
+			return fmt.Errorf("cannot open browser: %w", err)
+		}
+	}
+	return nil
+}
+
+// parseFlags accepts flags both before and after the alias, e.g. "kubectl-login prod --device-code".
+func parseFlags(args []string) (*options, []string) {
+	opts := &options{}
+	flags := flag.NewFlagSet("kubectl-login", flag.ExitOnError)
+	flags.BoolVar(&opts.deviceCode, "device-code", false, "login with the device authorization grant instead of a browser on this machine")
+	flags.StringVar(&opts.output, "output", "", "output format, json or env, instead of the kubeconfig path")
+	flags.BoolVar(&opts.all, "all", false, "login to every cluster, once per issuer and client")
+	flags.StringVar(&opts.issuer, "issuer", "", "login to every cluster of the issuer at once")
+	return opts, parseInterleaved(flags, args)
+}
+
+// parseInterleaved parses flags wherever they appear among the positional arguments and returns the positional ones.
+func parseInterleaved(flags *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		flags.Parse(args)
+		if flags.NArg() == 0 {
+			return positional
+		}
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
 	}
-	//output the new kubeconfig path, used in the wrapper to set the env variable
-	logger.Printf(newKubeconfig)
 }
 
 func isMasterConfig(kubeconfigPath string) bool {
 	return len(kubeconfigPath) > 0 && !strings.Contains(kubeconfigPath, "_")
 }
 
-func switchConfig(masterConfig, cluster string) string {
+// getMasterConfig returns the master kubeconfig, also when KUBECONFIG points at one of its per-cluster copies.
+func getMasterConfig(currentKubeconfig string) string {
+	if isMasterConfig(currentKubeconfig) {
+		return currentKubeconfig
+	}
+	return strings.Split(currentKubeconfig, "_")[0]
+}
+
+// requireMasterConfig returns the master kubeconfig of KUBECONFIG. Without one the per-cluster kubeconfigs
+// would be relative to the working directory, so commands which lock or remove them refuse to run.
+func requireMasterConfig() (string, error) {
+	masterKubeconfig := getMasterConfig(os.Getenv("KUBECONFIG"))
+	if masterKubeconfig == "" {
+		return "", errorf(exitKubeconfig, "KUBECONFIG is not set to a kubeconfig")
+	}
+	return masterKubeconfig, nil
+}
+
+// switchConfig replaces the per-cluster kubeconfig with a copy of the master one, or with only the cluster's entry when minify is set.
+func switchConfig(masterConfig, cluster string, minify bool) (string, error) {
 	clusterKubeconfig := getClusterConfig(masterConfig, cluster)
-	copyConfig(masterConfig, clusterKubeconfig)
-	return clusterKubeconfig
+	write := copyConfig
+	if minify {
+		write = func(srcPath, dstPath string) error { return copyMinifiedConfig(srcPath, dstPath, cluster) }
+	}
+	if err := write(masterConfig, clusterKubeconfig); err != nil {
+		return "", err
+	}
+	return clusterKubeconfig, nil
 }
 
 func getClusterConfig(masterConfig string, cluster string) string {
 	return masterConfig + "_" + cluster
 }
 
-func copyConfig(srcPath string, dstPath string) {
-	src, err := os.Open(srcPath)
+// copyConfig replaces dstPath with a copy of srcPath, which is only readable by the current user
+// whatever the permissions of srcPath, as it will hold the credentials of the cluster.
+func copyConfig(srcPath string, dstPath string) error {
+	data, err := ioutil.ReadFile(srcPath)
 	if err != nil {
-		logger.Fatalf("error: could not open kubeconfig %s: %v", srcPath, err)
+		return errorf(exitKubeconfig, "could not read kubeconfig %s: %w", srcPath, err)
+	}
+	if err := writeFileAtomic(dstPath, data); err != nil {
+		return errorf(exitKubeconfig, "could not copy kubeconfig %s to %s: %w", srcPath, dstPath, err)
 	}
-	defer src.Close()
+	return nil
+}
 
-	dst, err := os.Create(dstPath)
+// copyMinifiedConfig writes only the cluster's entry of srcPath to dstPath, with its certificate authority inlined
+// so that the file stands on its own. The user and the context are added by the login.
+func copyMinifiedConfig(srcPath, dstPath, cluster string) error {
+	k, err := loadKubeconfig(srcPath)
 	if err != nil {
-		logger.Fatalf("error: could not create kubeconfig %s: %v", dstPath, err)
+		return errorf(exitKubeconfig, "could not read kubeconfig %s: %w", srcPath, err)
 	}
-	defer dst.Close()
-
-	if _, err := io.Copy(dst, src); err != nil {
-		logger.Fatalf("error: could not copy kubeconfig %s to %s: %v", srcPath, dstPath, err)
+	minified, err := k.minify(cluster, filepath.Dir(srcPath))
+	if err != nil {
+		return errorf(exitKubeconfig, "could not minify kubeconfig %s: %w", srcPath, err)
+	}
+	if err := minified.save(dstPath); err != nil {
+		return errorf(exitKubeconfig, "could not write kubeconfig %s: %w", dstPath, err)
 	}
+	return nil
 }
 
 func openBrowser(url string) error {
 	var err error
 	switch runtime.GOOS {
 	case "linux":
-		err = exec.Command("xdg-open", url).Start()
+		err = startCommand("xdg-open", url).Start()
 	case "windows":
-		err = exec.Command("rundll32", "url.dll,FileProtocolHandler", url).Start()
+		err = startCommand("rundll32", "url.dll,FileProtocolHandler", url).Start()
 	case "darwin":
-		err = exec.Command("open", url).Start()
+		err = startCommand("open", url).Start()
 	default:
 		err = fmt.Errorf("unsupported platform: %v", runtime.GOOS)
 	}
 	return err
 }
 
-func getRawConfig() map[string]*configuration {
-	configPath := os.Getenv("HOME") + string(os.PathSeparator) + configFile
+// getConfigPath looks for the config file in $KUBECTL_LOGIN_CONFIG, then in
+// $XDG_CONFIG_HOME/kubectl-login/config.{yaml,json} and finally falls back to the legacy $HOME/.kubectl-login.json.
+func getConfigPath() string {
+	if configPath := os.Getenv(configPathEnv); configPath != "" {
+		return configPath
+	}
+
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		configHome = filepath.Join(os.Getenv("HOME"), ".config")
+	}
+	for _, name := range []string{"config.yaml", "config.json"} {
+		configPath := filepath.Join(configHome, "kubectl-login", name)
+		if _, err := os.Stat(configPath); err == nil {
+			return configPath
+		}
+	}
+	return os.Getenv("HOME") + string(os.PathSeparator) + configFile
+}
+
+// unmarshalConfig reads YAML for .yaml and .yml files and JSON otherwise.
+func unmarshalConfig(configPath string, data []byte, cfg *map[string]*configuration) error {
+	switch strings.ToLower(filepath.Ext(configPath)) {
+	case ".yaml", ".yml":
+		return yaml.Unmarshal(data, cfg)
+	default:
+		return json.Unmarshal(data, cfg)
+	}
+}
+
+func getRawConfig() (map[string]*configuration, error) {
+	configPath := getConfigPath()
 
 	file, err := os.Open(configPath)
 	if err != nil {
-		logger.Fatalf("error: cannot open config file at %s: %v", configPath, err)
+		return nil, errorf(exitConfig, "cannot open config file at %s: %w", configPath, err)
 	}
+	defer closeFile(file)
 
 	data, err := ioutil.ReadAll(file)
 	if err != nil {
-		closeFile(file)
-		logger.Fatalf("error: cannot read config file at %s: %v", configPath, err)
+		return nil, errorf(exitConfig, "cannot read config file at %s: %w", configPath, err)
 	}
 
 	var cfg map[string]*configuration
-	err = json.Unmarshal(data, &cfg)
+	err = unmarshalConfig(configPath, data, &cfg)
 	if err != nil {
-		closeFile(file)
-		logger.Fatalf("error: cannot unmarshal contents of config file at %s: %v", configPath, err)
+		return nil, errorf(exitConfig, "cannot unmarshal contents of config file at %s: %w", configPath, err)
 	}
-
-	closeFile(file)
-	return cfg
+	return cfg, nil
 }
 
-func getAlias(args []string) string {
+func getAlias(args []string) (string, error) {
 	if len(args) == 0 {
-		logger.Fatalf("Alias is mandatory i.e %s. try '%s' to get this value.",
-			Bold(Cyan("kubectl-login <ALIAS>")), Bold(Cyan("cat $HOME/"+configFile)))
+		return "", errorf(exitUsage, "Alias is mandatory i.e %s. try '%s' to get this value.",
+			Bold(Cyan("kubectl-login <ALIAS>")), Bold(Cyan("kubectl-login list")))
 	}
-	return args[0]
+	return args[0], nil
 }
 
 func closeFile(f *os.File) {
@@ -190,25 +713,37 @@ func closeFile(f *os.File) {
 	}
 }
 
-func getConfigByAlias(alias string, rawConfig map[string]*configuration) (*configuration, string) {
-	for k, v := range rawConfig {
-		if containsAlias(v, alias) {
-			return v, k
+// getConfigByAlias looks the clusters up in name order, so an alias shared by two clusters always resolves
+// to the same one. 'kubectl-login config validate' reports such aliases.
+func getConfigByAlias(alias string, rawConfig map[string]*configuration) (*configuration, string, error) {
+	clusters := make([]string, 0, len(rawConfig))
+	for k := range rawConfig {
+		clusters = append(clusters, k)
+	}
+	sort.Strings(clusters)
+	for _, k := range clusters {
+		if containsAlias(rawConfig[k], alias) {
+			return rawConfig[k], k, nil
 		}
 	}
-	logger.Fatalf("Alias \"%s\" not found. Try '%s' to get this value.",
-		Bold(Cyan(alias)), Bold(Cyan("cat $HOME/"+configFile)))
-	return nil, ""
+	return nil, "", errorf(exitAliasNotFound, "Alias \"%s\" not found. Try '%s' to get this value.",
+		Bold(Cyan(alias)), Bold(Cyan("kubectl-login list")))
 }
 
-func getKubeLogin(config *configuration) string {
+func getKubeLogin(config *configuration) (string, error) {
 	if os.Getenv("KUBELOGIN") != "" {
-		return os.Getenv("KUBELOGIN")
-	} else if config.LoginSecret != "" {
-		return config.LoginSecret
+		return os.Getenv("KUBELOGIN"), nil
+	} else if source := config.loginSecretSource(); source != nil {
+		secret, err := source.secret()
+		if err != nil {
+			return "", errorf(exitConfig, "cannot read the login secret from %s: %w", source, err)
+		}
+		return secret, nil
+	} else if config.PKCE {
+		// public clients protected by PKCE have no secret
+		return "", nil
 	} else {
-		logger.Fatal("KUBELOGIN is not set. You Can also set this in your ~/" + configFile + " file.")
-		return ""
+		return "", errorf(exitConfig, "KUBELOGIN is not set. You Can also set a loginSecret source in your %s file.", getConfigPath())
 	}
 }
 
@@ -221,68 +756,64 @@ func containsAlias(c *configuration, s string) bool {
 	return false
 }
 
-func extractTokens(combTkns string) (string, string) {
-	/*	dex-redirect will combine the id token with the refresh token into a single string separated by ";" */
+func extractTokens(combTkns string) (string, string, string) {
+	/*	dex-redirect will combine the id token with the refresh token and the state into a single string separated by ";" */
 	tkns := strings.Split(combTkns, tokensSeparator)
-	if len(tkns) == 1 {
-		return tkns[0], ""
-	} else {
-		return tkns[0], tkns[1]
+	switch len(tkns) {
+	case 1:
+		return tkns[0], "", ""
+	case 2:
+		return tkns[0], tkns[1], ""
+	default:
+		return tkns[0], tkns[1], tkns[2]
 	}
 }
 
-func setIdTokenCreds(token, config string) {
-	tstr := fmt.Sprintf("--token=%s", token)
-	cfg := fmt.Sprintf("--kubeconfig=%s", config)
-	cmd := exec.Command("kubectl", "config", "set-credentials", clientID, tstr, cfg)
-	err := cmd.Run()
+func setIdTokenCreds(userName, token, config string) error {
+	err := updateKubeconfig(config, func(k *kubeconfig) {
+		user := k.user(userName)
+		user.Token = token
+		user.AuthProvider = nil
+		user.Exec = nil
+	})
 	if err != nil {
-		logger.Fatalf("error: cannot set kubectl credentials: %v", err)
-	}
-}
-
-func setOIDCAuth(clientSecret, idToken, refreshToken, idpIssuerUrl, config string) {
-	cfg := fmt.Sprintf("--kubeconfig=%s", config)
-	authProv := fmt.Sprintf("--auth-provider=%s", oidcProvider)
-	authProvIdp := fmt.Sprintf("--auth-provider-arg=idp-issuer-url=%s", idpIssuerUrl)
-	authProvClientId := fmt.Sprintf("--auth-provider-arg=client-id=%s", clientID)
-	authProvClientSecret := fmt.Sprintf("--auth-provider-arg=client-secret=%s", clientSecret)
-	authProvIdToken := fmt.Sprintf("--auth-provider-arg=id-token=%s", idToken)
-	authProvRefreshToken := fmt.Sprintf("--auth-provider-arg=refresh-token=%s", refreshToken)
-
-	cmd := exec.Command("kubectl", "config", "set-credentials", clientID,
-		cfg,
-		authProv,
-		authProvIdp,
-		authProvClientId,
-		authProvClientSecret,
-		authProvIdToken,
-		authProvRefreshToken)
-	err := cmd.Run()
-	if err != nil {
-		logger.Fatalf("error: cannot set kubectl OIDC credentials: %v", err)
+		return errorf(exitKubeconfig, "cannot set kubectl credentials: %w", err)
 	}
+	return nil
 }
 
-func switchContext(cluster, config string) {
-	clusterArg := fmt.Sprintf("--cluster=%s", cluster)
-	user := fmt.Sprintf("--user=%s", clientID)
-	cfg := fmt.Sprintf("--kubeconfig=%s", config)
-	cmd := exec.Command("kubectl", "config", "set-context", cluster, user, clusterArg, "--namespace=default", cfg)
-	err := cmd.Run()
+func setOIDCAuth(userName, clientId, clientSecret, idToken, refreshToken, idpIssuerUrl, config string) error {
+	err := updateKubeconfig(config, func(k *kubeconfig) {
+		user := k.user(userName)
+		user.Token = ""
+		user.Exec = nil
+		user.AuthProvider = &kubeAuthProvider{
+			Name: oidcProvider,
+			Config: map[string]string{
+				"idp-issuer-url": idpIssuerUrl,
+				"client-id":      clientId,
+				"client-secret":  clientSecret,
+				"id-token":       idToken,
+				"refresh-token":  refreshToken,
+			},
+		}
+	})
 	if err != nil {
-		logger.Fatalf("error: cannot set kubectl login context: %v", err)
+		return errorf(exitKubeconfig, "cannot set kubectl OIDC credentials: %w", err)
 	}
+	return nil
+}
 
-	cmd = exec.Command("kubectl", "config", "use-context", cluster, cfg)
-	err = cmd.Run()
+func switchContext(cluster, contextName, userName, namespace, config string) error {
+	err := updateKubeconfig(config, func(k *kubeconfig) {
+		context := k.context(contextName)
+		context.Cluster = cluster
+		context.User = userName
+		context.Namespace = namespace
+		k.CurrentContext = contextName
+	})
 	if err != nil {
-		logger.Fatalf("error: cannot switch to kubectl login context: %v", err)
+		return errorf(exitKubeconfig, "cannot set kubectl login context: %w", err)
 	}
-}
-
-func isLoggedIn(config string) bool {
-	cfg := fmt.Sprintf("--kubeconfig=%s", config)
-	err := exec.Command("kubectl", "get", "namespace", cfg).Run()
-	return err == nil
+	return nil
 }
@@ -1,15 +1,23 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
 	"io/ioutil"
-	"os/exec"
+	"net/url"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"os"
 
 	"encoding/json"
 
+	"github.com/coreos/go-oidc"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
+	"gopkg.in/square/go-jose.v2"
 	"gopkg.in/yaml.v2"
 )
 
@@ -48,7 +56,8 @@ func TestSwitchConfig(t *testing.T) {
 	src.Write(expectedData)
 
 	masterConfig := src.Name()
-	clusterConfig := switchConfig(masterConfig, "cluster-test")
+	clusterConfig, err := switchConfig(masterConfig, "cluster-test", false)
+	assert.NoError(t, err)
 
 	assert.True(t, clusterConfig == masterConfig+"_cluster-test")
 
@@ -69,7 +78,7 @@ func TestCopyConfigSuccess(t *testing.T) {
 	dst := os.TempDir() + string(os.PathSeparator) + "test_dst"
 	defer os.Remove(dst)
 
-	copyConfig(src.Name(), dst)
+	assert.NoError(t, copyConfig(src.Name(), dst))
 
 	actualData, err := ioutil.ReadFile(dst)
 	if err != nil {
@@ -80,66 +89,43 @@ func TestCopyConfigSuccess(t *testing.T) {
 }
 
 func TestCopyConfigWrongSrcPath(t *testing.T) {
-	if os.Getenv("CRASH") == "true" {
-		copyConfig("", "")
-		return
-	}
-	cmd := exec.Command(os.Args[0], "-test.run=TestCopyConfigWrongSrcPath")
-	cmd.Env = append(os.Environ(), "CRASH=true")
-	err := cmd.Run()
-	if e, ok := err.(*exec.ExitError); ok && !e.Success() {
-		return
-	}
-	t.Fatal("copyConfig should exit if cannot open source file")
+	err := copyConfig("", "")
+	assert.Error(t, err, "copyConfig should fail if cannot open source file")
+	assert.Equal(t, exitKubeconfig, exitCodeOf(err))
 }
 
 func TestCopyConfigWrongDstPath(t *testing.T) {
-	if os.Getenv("CRASH") == "true" {
-		file, _ := ioutil.TempFile(os.TempDir(), "prefix")
-		defer os.Remove(file.Name())
-		copyConfig(file.Name(), "")
-		return
-	}
-	cmd := exec.Command(os.Args[0], "-test.run=TestCopyConfigWrongDstPath")
-	cmd.Env = append(os.Environ(), "CRASH=true")
-	err := cmd.Run()
-	if e, ok := err.(*exec.ExitError); ok && !e.Success() {
-		return
-	}
-	t.Fatal("copyConfig should exit if cannot create destination file")
+	file, _ := ioutil.TempFile(os.TempDir(), "prefix")
+	defer os.Remove(file.Name())
+
+	err := copyConfig(file.Name(), "")
+	assert.Error(t, err, "copyConfig should fail if cannot create destination file")
+	assert.Equal(t, exitKubeconfig, exitCodeOf(err))
 }
 
 func TestGetRawConfigFileNotFound(t *testing.T) {
-	if os.Getenv("CRASH") == "true" {
-		getRawConfig()
-		return
-	}
-	cmd := exec.Command(os.Args[0], "-test.run=TestGetRawConfigFileNotFound")
-	cmd.Env = append(os.Environ(), "CRASH=true")
-	cmd.Env = append(cmd.Env, "HOME=.")
-	err := cmd.Run()
-	if e, ok := err.(*exec.ExitError); ok && !e.Success() {
-		return
-	}
-	t.Fatal("getRawConfig should exit on config file not found")
+	originalConfig := os.Getenv("KUBECTL_LOGIN_CONFIG")
+	defer os.Setenv("KUBECTL_LOGIN_CONFIG", originalConfig)
+	os.Setenv("KUBECTL_LOGIN_CONFIG", filepath.Join(os.TempDir(), "does-not-exist.json"))
+
+	_, err := getRawConfig()
+	assert.Error(t, err, "getRawConfig should fail on config file not found")
+	assert.Equal(t, exitConfig, exitCodeOf(err))
 }
 
 func TestGetRawConfigInvalidContents(t *testing.T) {
-	if os.Getenv("CRASH") == "true" {
-		file, _ := ioutil.TempFile(os.TempDir(), "prefix")
-		defer os.Remove(file.Name())
-		file.Write([]byte("this is not a {valid} json content"))
-		file.Sync()
-		getRawConfig()
-		return
-	}
-	cmd := exec.Command(os.Args[0], "-test.run=TestGetRawConfigFileNotFound")
-	cmd.Env = append(os.Environ(), "CRASH=true", "HOME="+os.TempDir())
-	err := cmd.Run()
-	if e, ok := err.(*exec.ExitError); ok && !e.Success() {
-		return
-	}
-	t.Fatal("getRawConfig should exit on invalid config file contents")
+	file, _ := ioutil.TempFile(os.TempDir(), "prefix")
+	defer os.Remove(file.Name())
+	file.Write([]byte("this is not a {valid} json content"))
+	file.Sync()
+
+	originalConfig := os.Getenv("KUBECTL_LOGIN_CONFIG")
+	defer os.Setenv("KUBECTL_LOGIN_CONFIG", originalConfig)
+	os.Setenv("KUBECTL_LOGIN_CONFIG", file.Name())
+
+	_, err := getRawConfig()
+	assert.Error(t, err, "getRawConfig should fail on invalid config file contents")
+	assert.Equal(t, exitConfig, exitCodeOf(err))
 }
 
 func TestGetRawConfigValidConfig(t *testing.T) {
@@ -152,10 +138,57 @@ func TestGetRawConfigValidConfig(t *testing.T) {
 	defer os.Setenv("HOME", originalHome)
 	os.Setenv("HOME", os.TempDir())
 
-	actualConfig := getRawConfig()
+	actualConfig, err := getRawConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, validConfig, actualConfig)
+}
+
+func TestGetRawConfigYAML(t *testing.T) {
+	home, _ := ioutil.TempDir(os.TempDir(), "home")
+	defer os.RemoveAll(home)
+	marshaledConfig, _ := yaml.Marshal(validConfig)
+	configPath := filepath.Join(home, "config.yaml")
+	ioutil.WriteFile(configPath, append([]byte("# shared UPP clusters\n"), marshaledConfig...), 0644)
+
+	originalConfig := os.Getenv("KUBECTL_LOGIN_CONFIG")
+	defer os.Setenv("KUBECTL_LOGIN_CONFIG", originalConfig)
+	os.Setenv("KUBECTL_LOGIN_CONFIG", configPath)
+
+	actualConfig, err := getRawConfig()
+	assert.NoError(t, err)
 	assert.Equal(t, validConfig, actualConfig)
 }
 
+func TestGetConfigPath(t *testing.T) {
+	home, _ := ioutil.TempDir(os.TempDir(), "home")
+	defer os.RemoveAll(home)
+	xdgConfigHome := filepath.Join(home, "xdg")
+	os.MkdirAll(filepath.Join(xdgConfigHome, "kubectl-login"), 0755)
+	os.MkdirAll(filepath.Join(home, ".config", "kubectl-login"), 0755)
+
+	for _, env := range []string{"HOME", "XDG_CONFIG_HOME", "KUBECTL_LOGIN_CONFIG"} {
+		defer os.Setenv(env, os.Getenv(env))
+	}
+	os.Setenv("HOME", home)
+	os.Unsetenv("KUBECTL_LOGIN_CONFIG")
+	os.Unsetenv("XDG_CONFIG_HOME")
+
+	assert.Equal(t, filepath.Join(home, configFile), getConfigPath(), "legacy path when nothing else exists")
+
+	ioutil.WriteFile(filepath.Join(home, ".config", "kubectl-login", "config.json"), []byte("{}"), 0644)
+	assert.Equal(t, filepath.Join(home, ".config", "kubectl-login", "config.json"), getConfigPath(), "XDG_CONFIG_HOME defaults to ~/.config")
+
+	os.Setenv("XDG_CONFIG_HOME", xdgConfigHome)
+	ioutil.WriteFile(filepath.Join(xdgConfigHome, "kubectl-login", "config.json"), []byte("{}"), 0644)
+	assert.Equal(t, filepath.Join(xdgConfigHome, "kubectl-login", "config.json"), getConfigPath())
+
+	ioutil.WriteFile(filepath.Join(xdgConfigHome, "kubectl-login", "config.yaml"), []byte("{}"), 0644)
+	assert.Equal(t, filepath.Join(xdgConfigHome, "kubectl-login", "config.yaml"), getConfigPath(), "yaml takes precedence over json")
+
+	os.Setenv("KUBECTL_LOGIN_CONFIG", "/etc/kubectl-login.yaml")
+	assert.Equal(t, "/etc/kubectl-login.yaml", getConfigPath())
+}
+
 func TestGetAliasSuccessfully(t *testing.T) {
 	var testCases = []struct {
 		args          []string
@@ -171,92 +204,193 @@ func TestGetAliasSuccessfully(t *testing.T) {
 		},
 	}
 	for _, tc := range testCases {
-		actualAlias := getAlias(tc.args)
+		actualAlias, err := getAlias(tc.args)
+		assert.NoError(t, err)
 		assert.Equal(t, tc.expectedAlias, actualAlias)
 	}
 }
 
+func TestParseFlags(t *testing.T) {
+	var testCases = []struct {
+		args               []string
+		expectedDeviceCode bool
+		expectedOutput     string
+		expectedArgs       []string
+	}{
+		{
+			args:               []string{"alias"},
+			expectedDeviceCode: false,
+			expectedArgs:       []string{"alias"},
+		},
+		{
+			args:               []string{"--device-code", "alias"},
+			expectedDeviceCode: true,
+			expectedArgs:       []string{"alias"},
+		},
+		{
+			args:               []string{"alias", "--device-code"},
+			expectedDeviceCode: true,
+			expectedArgs:       []string{"alias"},
+		},
+		{
+			args:               []string{"alias", "--output", "json"},
+			expectedDeviceCode: false,
+			expectedOutput:     "json",
+			expectedArgs:       []string{"alias"},
+		},
+		{
+			args:               []string{},
+			expectedDeviceCode: false,
+			expectedArgs:       nil,
+		},
+	}
+	for _, tc := range testCases {
+		opts, args := parseFlags(tc.args)
+		assert.Equal(t, tc.expectedDeviceCode, opts.deviceCode)
+		assert.Equal(t, tc.expectedOutput, opts.output)
+		assert.Equal(t, tc.expectedArgs, args)
+	}
+}
+
 func TestExtractTokens(t *testing.T) {
 	idToken := "Hjjhhasdft.ADDGfaerrgg.asdf"
 	refreshToken := "HLKKDFfdgggAAA"
+	loginState := "c3RhdGVzdGF0ZQ"
 	var testCases = []struct {
 		description          string
 		input                string
 		expectedIdToken      string
 		expectedRefreshToken string
+		expectedState        string
 	}{
 		{
 			description:          "idtoken + refresh token happy case",
 			input:                idToken + ";" + refreshToken,
 			expectedIdToken:      idToken,
 			expectedRefreshToken: refreshToken,
+			expectedState:        "",
 		},
 		{
 			description:          "idtoken only",
 			input:                idToken,
 			expectedIdToken:      idToken,
 			expectedRefreshToken: "",
+			expectedState:        "",
 		},
 		{
 			description:          "empty input",
 			input:                "",
 			expectedIdToken:      "",
 			expectedRefreshToken: "",
+			expectedState:        "",
+		},
+		{
+			description:          "idtoken + refresh token + state",
+			input:                idToken + ";" + refreshToken + ";" + loginState,
+			expectedIdToken:      idToken,
+			expectedRefreshToken: refreshToken,
+			expectedState:        loginState,
 		},
 		{
-			description:          "more than 3 tokens included. one is unknown, but we should ignore it",
-			input:                idToken + ";" + refreshToken + ";" + "some other nonsense",
+			description:          "more than 3 tokens included. the unknown ones should be ignored",
+			input:                idToken + ";" + refreshToken + ";" + loginState + ";" + "some other nonsense",
 			expectedIdToken:      idToken,
 			expectedRefreshToken: refreshToken,
+			expectedState:        loginState,
 		},
 	}
 	for _, tc := range testCases {
-		actualIdToken, actualRefreshToken := extractTokens(tc.input)
+		actualIdToken, actualRefreshToken, actualState := extractTokens(tc.input)
 		assert.Equal(t, tc.expectedIdToken, actualIdToken, "Scenario: "+tc.description)
 		assert.Equal(t, tc.expectedRefreshToken, actualRefreshToken, "Scenario: "+tc.description)
+		assert.Equal(t, tc.expectedState, actualState, "Scenario: "+tc.description)
 	}
 }
 
-func TestGetAliasFailure(t *testing.T) {
-	if os.Getenv("CRASH") == "true" {
-		getAlias([]string{})
-		return
+func TestVerifyIdTokenNonce(t *testing.T) {
+	var testCases = []struct {
+		description string
+		tokenNonce  string
+		nonce       string
+		expectError bool
+	}{
+		{
+			description: "nonce matches",
+			tokenNonce:  "expected-nonce",
+			nonce:       "expected-nonce",
+		},
+		{
+			description: "nonce does not match",
+			tokenNonce:  "replayed-nonce",
+			nonce:       "expected-nonce",
+			expectError: true,
+		},
+		{
+			description: "nonce missing from token",
+			tokenNonce:  "",
+			nonce:       "expected-nonce",
+			expectError: true,
+		},
+		{
+			description: "no nonce expected",
+			tokenNonce:  "",
+			nonce:       "",
+		},
 	}
-	cmd := exec.Command(os.Args[0], "-test.run=TestGetAliasFailure")
-	cmd.Env = append(os.Environ(), "CRASH=true")
-	err := cmd.Run()
-	if e, ok := err.(*exec.ExitError); ok && !e.Success() {
-		return
+	for _, tc := range testCases {
+		claims := validTestClaims()
+		if tc.tokenNonce != "" {
+			claims["nonce"] = tc.tokenNonce
+		}
+		rawIdToken := signTestToken(t, claims)
+
+		err := verifyIdToken(context.Background(), newTestVerifier(), rawIdToken, tc.nonce)
+		if tc.expectError {
+			assert.Error(t, err, "Scenario: "+tc.description)
+		} else {
+			assert.NoError(t, err, "Scenario: "+tc.description)
+		}
 	}
-	t.Fatal("getAlias should exit on empty args list")
+}
+
+func TestIdTokenExpiry(t *testing.T) {
+	claims := validTestClaims()
+	claims["exp"] = int64(1900000000)
+	expiry, err := idTokenExpiry(signTestToken(t, claims))
+	assert.NoError(t, err)
+	assert.Equal(t, time.Unix(1900000000, 0), expiry)
+
+	delete(claims, "exp")
+	_, err = idTokenExpiry(signTestToken(t, claims))
+	assert.Error(t, err)
+
+	_, err = idTokenExpiry("not-a-jwt")
+	assert.Error(t, err)
+}
+
+func TestNewRandomToken(t *testing.T) {
+	first := newRandomToken()
+	second := newRandomToken()
+	assert.Len(t, first, 43)
+	assert.NotEqual(t, first, second)
+}
+
+func TestGetAliasFailure(t *testing.T) {
+	_, err := getAlias([]string{})
+	assert.Error(t, err, "getAlias should fail on empty args list")
+	assert.Equal(t, exitUsage, exitCodeOf(err))
 }
 
 func TestGetConfigByAliasEmptyConfigMap(t *testing.T) {
-	if os.Getenv("CRASH") == "true" {
-		getConfigByAlias("alias", map[string]*configuration{})
-		return
-	}
-	cmd := exec.Command(os.Args[0], "-test.run=TestGetConfigByAliasEmptyConfigMap")
-	cmd.Env = append(os.Environ(), "CRASH=true")
-	err := cmd.Run()
-	if e, ok := err.(*exec.ExitError); ok && !e.Success() {
-		return
-	}
-	t.Fatal("getConfigByAlias should exit on empty configmap")
+	_, _, err := getConfigByAlias("alias", map[string]*configuration{})
+	assert.Error(t, err, "getConfigByAlias should fail on empty configmap")
+	assert.Equal(t, exitAliasNotFound, exitCodeOf(err))
 }
 
 func TestGetConfigByAliasNotFound(t *testing.T) {
-	if os.Getenv("CRASH") == "true" {
-		getConfigByAlias("alias1", map[string]*configuration{"config1": {Aliases: []string{"alias2", "alias3"}}})
-		return
-	}
-	cmd := exec.Command(os.Args[0], "-test.run=TestGetConfigByAliasNotFound")
-	cmd.Env = append(os.Environ(), "CRASH=true")
-	err := cmd.Run()
-	if e, ok := err.(*exec.ExitError); ok && !e.Success() {
-		return
-	}
-	t.Fatal("getConfigByAlias should exit on alias not found in configmap")
+	_, _, err := getConfigByAlias("alias1", map[string]*configuration{"config1": {Aliases: []string{"alias2", "alias3"}}})
+	assert.Error(t, err, "getConfigByAlias should fail on alias not found in configmap")
+	assert.Equal(t, exitAliasNotFound, exitCodeOf(err))
 }
 
 func TestGetConfigByAliasSuccessCases(t *testing.T) {
@@ -291,24 +425,17 @@ func TestGetConfigByAliasSuccessCases(t *testing.T) {
 		},
 	}
 	for _, tc := range testCases {
-		actualConfig, actualConfigName := getConfigByAlias(tc.alias, tc.configs)
+		actualConfig, actualConfigName, err := getConfigByAlias(tc.alias, tc.configs)
+		assert.NoError(t, err)
 		assert.Equal(t, tc.expectedConfig, actualConfig)
 		assert.Equal(t, tc.expectedConfigName, actualConfigName)
 	}
 }
 
 func TestGetKubeLoginNotSet(t *testing.T) {
-	if os.Getenv("CRASH") == "true" {
-		getKubeLogin(&configuration{})
-		return
-	}
-	cmd := exec.Command(os.Args[0], "-test.run=TestGetKubeLoginNotSet")
-	cmd.Env = append(os.Environ(), "CRASH=true")
-	err := cmd.Run()
-	if e, ok := err.(*exec.ExitError); ok && !e.Success() {
-		return
-	}
-	t.Fatal("getKubeLogin should exit on kubelogin not founds")
+	_, err := getKubeLogin(&configuration{})
+	assert.Error(t, err, "getKubeLogin should fail on kubelogin not found")
+	assert.Equal(t, exitConfig, exitCodeOf(err))
 }
 
 func TestGetKubeLoginSuccessCases(t *testing.T) {
@@ -327,17 +454,125 @@ func TestGetKubeLoginSuccessCases(t *testing.T) {
 			envVar:            "secret1",
 			expectedKubeLogin: "secret1",
 		},
+		{
+			config:            &configuration{PKCE: true},
+			envVar:            "",
+			expectedKubeLogin: "",
+		},
+		{
+			config:            &configuration{LoginSecret: "secret1", PKCE: true},
+			envVar:            "",
+			expectedKubeLogin: "secret1",
+		},
 	}
 	for _, tc := range testCases {
 		if len(tc.envVar) > 0 {
 			os.Setenv("KUBELOGIN", tc.expectedKubeLogin)
 		}
-		actualKubeLogin := getKubeLogin(tc.config)
+		actualKubeLogin, err := getKubeLogin(tc.config)
+		assert.NoError(t, err)
 		assert.Equal(t, tc.expectedKubeLogin, actualKubeLogin)
 		os.Unsetenv("KUBELOGIN")
 	}
 }
 
+func TestGetClientIDAndScopes(t *testing.T) {
+	var testCases = []struct {
+		config           *configuration
+		expectedClientID string
+		expectedScopes   []string
+	}{
+		{
+			config:           &configuration{},
+			expectedClientID: "kubectl-login",
+			expectedScopes:   []string{"openid", "profile", "email", "groups", "offline_access"},
+		},
+		{
+			config:           &configuration{ClientID: "upp-github", Scopes: []string{"openid", "email", "groups"}},
+			expectedClientID: "upp-github",
+			expectedScopes:   []string{"openid", "email", "groups"},
+		},
+		{
+			config:           &configuration{Scopes: []string{"email", "offline_access"}},
+			expectedClientID: "kubectl-login",
+			expectedScopes:   []string{"openid", "email", "offline_access"},
+		},
+		{
+			config:           &configuration{Audience: "upp-prod-eu"},
+			expectedClientID: "kubectl-login",
+			expectedScopes: []string{"openid", "profile", "email", "groups", "offline_access",
+				"audience:server:client_id:kubectl-login", "audience:server:client_id:upp-prod-eu"},
+		},
+		{
+			config:           &configuration{Audience: "kubectl-login"},
+			expectedClientID: "kubectl-login",
+			expectedScopes:   []string{"openid", "profile", "email", "groups", "offline_access"},
+		},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expectedClientID, tc.config.getClientID())
+		assert.Equal(t, tc.expectedScopes, tc.config.getScopes())
+	}
+}
+
+func TestGetContextSettings(t *testing.T) {
+	var testCases = []struct {
+		config              *configuration
+		expectedNamespace   string
+		expectedContextName string
+		expectedUserName    string
+	}{
+		{
+			config:              &configuration{},
+			expectedNamespace:   "default",
+			expectedContextName: "config1",
+			expectedUserName:    "kubectl-login",
+		},
+		{
+			config:              &configuration{Namespace: "upp", ContextName: "publishing", UserName: "jane"},
+			expectedNamespace:   "upp",
+			expectedContextName: "publishing",
+			expectedUserName:    "jane",
+		},
+		{
+			config:              &configuration{UserPerCluster: true},
+			expectedNamespace:   "default",
+			expectedContextName: "config1",
+			expectedUserName:    "config1",
+		},
+		{
+			config:              &configuration{UserName: "jane", UserPerCluster: true},
+			expectedNamespace:   "default",
+			expectedContextName: "config1",
+			expectedUserName:    "jane",
+		},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expectedNamespace, tc.config.getNamespace())
+		assert.Equal(t, tc.expectedContextName, tc.config.getContextName("config1"))
+		assert.Equal(t, tc.expectedUserName, tc.config.getUserName("config1"))
+	}
+}
+
+func TestGetAuthCodeOptions(t *testing.T) {
+	config := &configuration{
+		ClientID:   "upp-github",
+		AuthParams: map[string]string{"prompt": "consent", "connector_id": "github", "login_hint": "jane.doe@ft.com"},
+	}
+	oauth2Config := newOAuth2Config(config, oauth2.Endpoint{AuthURL: "https://dex.ft.com/auth"}, "secret")
+
+	authURL, err := url.Parse(oauth2Config.AuthCodeURL("state", config.getAuthCodeOptions()...))
+	assert.NoError(t, err)
+	query := authURL.Query()
+	assert.Equal(t, "upp-github", query.Get("client_id"))
+	assert.Equal(t, "github", query.Get("connector_id"))
+	assert.Equal(t, "consent", query.Get("prompt"))
+	assert.Equal(t, "jane.doe@ft.com", query.Get("login_hint"))
+	assert.Equal(t, "openid profile email groups offline_access", query.Get("scope"))
+
+	assert.Empty(t, (&configuration{}).getAuthCodeOptions())
+}
+
 func TestContainsAlias(t *testing.T) {
 	var testCases = []struct {
 		config         *configuration
@@ -377,17 +612,13 @@ func TestContainsAlias(t *testing.T) {
 }
 
 func TestSetIdTokenCreds(t *testing.T) {
-	if os.Getenv("KUBECTL_AVAILABLE") == "FALSE" {
-		t.Skip("skipping test: kubectl is not available")
-	}
-
 	kubeConfig, _ := ioutil.TempFile(os.TempDir(), "test")
 	defer os.Remove(kubeConfig.Name())
 	kubeConfig.Write([]byte(testKubeconfig))
 	kubeConfig.Sync()
 
 	expectedToken := "WQ1NDZiOGZkMTA4NWFkMzExZ"
-	setIdTokenCreds(expectedToken, kubeConfig.Name())
+	setIdTokenCreds(clientID, expectedToken, kubeConfig.Name())
 
 	newKubeconfigRaw, _ := ioutil.ReadFile(kubeConfig.Name())
 	newKubeconfig := parseIdTokenConfig(newKubeconfigRaw, t)
@@ -396,10 +627,6 @@ func TestSetIdTokenCreds(t *testing.T) {
 }
 
 func TestSetOIDCCreds(t *testing.T) {
-	if os.Getenv("KUBECTL_AVAILABLE") == "FALSE" {
-		t.Skip("skipping test: kubectl is not available")
-	}
-
 	kubeConfig, _ := ioutil.TempFile(os.TempDir(), "test")
 	defer os.Remove(kubeConfig.Name())
 	kubeConfig.Write([]byte(testKubeconfig))
@@ -409,7 +636,8 @@ func TestSetOIDCCreds(t *testing.T) {
 	expClientSecret := "llldGgadfgkKjadfllj"
 	expRefreshToken := "GHHHDLKJLKJDOIIKL"
 	expIdpIssuerUrl := "https://upp-k8s-dev-delivery-eu-dex.ft.com"
-	setOIDCAuth(expClientSecret, expToken, expRefreshToken, expIdpIssuerUrl, kubeConfig.Name())
+	expClientId := "upp-github"
+	setOIDCAuth(clientID, expClientId, expClientSecret, expToken, expRefreshToken, expIdpIssuerUrl, kubeConfig.Name())
 
 	newKubeconfigRaw, _ := ioutil.ReadFile(kubeConfig.Name())
 	newKubeconfig := parseOIDCAuthConfig(newKubeconfigRaw, t)
@@ -419,16 +647,12 @@ func TestSetOIDCCreds(t *testing.T) {
 	oauthConfig := newKubeconfig.Users[0].OIDCUserData.OIDCAuthProvider.OIDCAuthProviderConfig
 	assert.Equal(t, expToken, oauthConfig.IDToken)
 	assert.Equal(t, expClientSecret, oauthConfig.ClientSecret)
-	assert.Equal(t, clientID, oauthConfig.ClientID)
+	assert.Equal(t, expClientId, oauthConfig.ClientID)
 	assert.Equal(t, expRefreshToken, oauthConfig.RefreshToken)
 	assert.Equal(t, expIdpIssuerUrl, oauthConfig.IDPIssuerURL)
 }
 
 func TestSetSwitchContext(t *testing.T) {
-	if os.Getenv("KUBECTL_AVAILABLE") == "FALSE" {
-		t.Skip("skipping test: kubectl is not available")
-	}
-
 	kubeConfig, _ := ioutil.TempFile(os.TempDir(), "test")
 	defer os.Remove(kubeConfig.Name())
 	kubeConfig.Write([]byte(testKubeconfig))
@@ -442,7 +666,7 @@ func TestSetSwitchContext(t *testing.T) {
 			User: "kubectl-login",
 		},
 	}
-	switchContext(expectedCluster.Name, kubeConfig.Name())
+	switchContext(expectedCluster.Name, expectedCluster.Name, clientID, "default", kubeConfig.Name())
 
 	newKubeconfigRaw, _ := ioutil.ReadFile(kubeConfig.Name())
 	newKubeconfig := parseIdTokenConfig(newKubeconfigRaw, t)
@@ -452,6 +676,21 @@ func TestSetSwitchContext(t *testing.T) {
 	assert.Contains(t, newKubeconfig.Contexts, expectedCluster )
 }
 
+func TestSwitchContextNamed(t *testing.T) {
+	path := writeTempKubeconfig(t, testKubeconfig)
+	defer os.Remove(path)
+
+	switchContext("k8s-test-publishing-cluster", "publishing", "k8s-test-publishing-cluster", "upp", path)
+
+	k, err := loadKubeconfig(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "publishing", k.CurrentContext)
+	context := k.context("publishing")
+	assert.Equal(t, "k8s-test-publishing-cluster", context.Cluster)
+	assert.Equal(t, "k8s-test-publishing-cluster", context.User)
+	assert.Equal(t, "upp", context.Namespace)
+}
+
 var validConfig = map[string]*configuration{
 	"config1": {
 		Issuer:      "https://upp-k8s-cluster.ft.com",
@@ -571,6 +810,52 @@ type ContextData struct {
 	User        string `yaml:"user"`
 }
 
+const testIssuer = "https://upp-k8s-dev-delivery-eu-dex.ft.com"
+
+var testSigningKey, _ = rsa.GenerateKey(rand.Reader, 2048)
+
+type testKeySet struct{}
+
+func (testKeySet) VerifySignature(ctx context.Context, jwt string) ([]byte, error) {
+	jws, err := jose.ParseSigned(jwt)
+	if err != nil {
+		return nil, err
+	}
+	return jws.Verify(&testSigningKey.PublicKey)
+}
+
+func newTestVerifier() *oidc.IDTokenVerifier {
+	return oidc.NewVerifier(testIssuer, testKeySet{}, &oidc.Config{ClientID: clientID})
+}
+
+func validTestClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"iss":   testIssuer,
+		"sub":   "CiQwOGE4Njg0Yi1kYjg4LTRiNzMtOTBhOS0zY2QxNjYxZjU0NjYSBWxvY2Fs",
+		"aud":   clientID,
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"iat":   time.Now().Unix(),
+		"email": "jane.doe@ft.com",
+	}
+}
+
+func signTestToken(t *testing.T, claims map[string]interface{}) string {
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: testSigningKey}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, _ := json.Marshal(claims)
+	jws, err := signer.Sign(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := jws.CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
 func parseIdTokenConfig(rawConfig []byte, t *testing.T) IdTokenKubeConfig {
 	var config IdTokenKubeConfig
 	if err := yaml.Unmarshal(rawConfig, &config); err != nil {
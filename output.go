@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// outputEnv is only supported by login: it prints a line for the shell to eval.
+const outputEnv = "env"
+
+// loginResult is what login prints with --output json. The keys are a contract with the shell wrappers.
+type loginResult struct {
+	Kubeconfig string `json:"kubeconfig"`
+	Cluster    string `json:"cluster"`
+	LoggedIn   bool   `json:"loggedIn"`
+	// LoginURL is empty when no interactive login was needed.
+	LoginURL string `json:"loginUrl"`
+}
+
+func validLoginOutput(output string) bool {
+	switch output {
+	case "", outputJSON, outputEnv:
+		return true
+	default:
+		return false
+	}
+}
+
+// writeLoginResult writes the only stdout output of login. By default that is the kubeconfig path, as the
+// existing wrappers expect. The json document is written even when the login failed, the env line only on success.
+func writeLoginResult(w io.Writer, output string, result *loginResult) error {
+	switch output {
+	case outputJSON:
+		return json.NewEncoder(w).Encode(result)
+	case outputEnv:
+		if !result.LoggedIn {
+			return nil
+		}
+		_, err := fmt.Fprintf(w, "export KUBECONFIG=%s\n", shellQuote(result.Kubeconfig))
+		return err
+	default:
+		if !result.LoggedIn {
+			return nil
+		}
+		_, err := fmt.Fprintln(w, result.Kubeconfig)
+		return err
+	}
+}
+
+// writeLoginResults writes the result of logging in to several clusters: a json array, or the path of every
+// kubeconfig logged in to. There is no single KUBECONFIG to switch to, so nothing is written for env.
+func writeLoginResults(w io.Writer, output string, results []*loginResult) error {
+	switch output {
+	case outputJSON:
+		return json.NewEncoder(w).Encode(results)
+	case outputEnv:
+		return nil
+	default:
+		for _, result := range results {
+			if result.LoggedIn {
+				if _, err := fmt.Fprintln(w, result.Kubeconfig); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+}
+
+// shellQuote single quotes s for POSIX shells and fish, so paths with spaces survive eval.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// loginPrompt tells the user where to log in. Everything goes to out, which is stderr,
+// and the URL is kept for the loginUrl of the json output.
+type loginPrompt struct {
+	out io.Writer
+	url string
+}
+
+func (p *loginPrompt) open(url string) error {
+	p.url = url
+	fmt.Fprintln(p.out, url)
+	return launchBrowser(url)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteLoginResult(t *testing.T) {
+	loggedIn := &loginResult{
+		Kubeconfig: "/home/jane/my kubeconfig_config1",
+		Cluster:    "config1",
+		LoggedIn:   true,
+		LoginURL:   "https://dex.ft.com/auth?state=abc",
+	}
+	failed := &loginResult{Kubeconfig: "/home/jane/kubeconfig_config1", Cluster: "config1"}
+
+	var testCases = []struct {
+		description    string
+		output         string
+		result         *loginResult
+		expectedOutput string
+	}{
+		{
+			description:    "default is the kubeconfig path",
+			output:         "",
+			result:         loggedIn,
+			expectedOutput: "/home/jane/my kubeconfig_config1\n",
+		},
+		{
+			description:    "nothing by default on failure",
+			output:         "",
+			result:         failed,
+			expectedOutput: "",
+		},
+		{
+			description:    "json",
+			output:         outputJSON,
+			result:         loggedIn,
+			expectedOutput: `{"kubeconfig":"/home/jane/my kubeconfig_config1","cluster":"config1","loggedIn":true,"loginUrl":"https://dex.ft.com/auth?state=abc"}` + "\n",
+		},
+		{
+			description:    "json on failure",
+			output:         outputJSON,
+			result:         failed,
+			expectedOutput: `{"kubeconfig":"/home/jane/kubeconfig_config1","cluster":"config1","loggedIn":false,"loginUrl":""}` + "\n",
+		},
+		{
+			description:    "env",
+			output:         outputEnv,
+			result:         loggedIn,
+			expectedOutput: "export KUBECONFIG='/home/jane/my kubeconfig_config1'\n",
+		},
+		{
+			description:    "nothing to eval on failure",
+			output:         outputEnv,
+			result:         failed,
+			expectedOutput: "",
+		},
+	}
+	for _, tc := range testCases {
+		var out bytes.Buffer
+		assert.NoError(t, writeLoginResult(&out, tc.output, tc.result), "Scenario: "+tc.description)
+		assert.Equal(t, tc.expectedOutput, out.String(), "Scenario: "+tc.description)
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	for _, path := range []string{"/home/jane/kubeconfig", "/home/jane/my kubeconfig", "/home/jane/it's; rm -rf ~"} {
+		out, err := exec.Command("sh", "-c", "eval \"KUBECONFIG=$0\" && printf %s \"$KUBECONFIG\"", shellQuote(path)).Output()
+		assert.NoError(t, err)
+		assert.Equal(t, path, string(out))
+	}
+}
+
+func TestLoginUnknownOutput(t *testing.T) {
+	err := login([]string{"alias1", "--output", "yaml"})
+	assert.Error(t, err)
+	assert.Equal(t, exitUsage, exitCodeOf(err))
+}
+
+func TestLoginPromptOpen(t *testing.T) {
+	originalPath := os.Getenv("PATH")
+	defer os.Setenv("PATH", originalPath)
+	// without a browser to open the URL is only printed
+	os.Setenv("PATH", "")
+
+	var out bytes.Buffer
+	prompt := &loginPrompt{out: &out}
+	assert.NoError(t, prompt.open("https://dex.ft.com/auth"))
+	assert.Equal(t, "https://dex.ft.com/auth\n", out.String())
+	assert.Equal(t, "https://dex.ft.com/auth", prompt.url)
+}
+
+func TestLoggerWritesToStderr(t *testing.T) {
+	// stdout is parsed by the shell integration and kubectl, warnings must not end up there
+	assert.Equal(t, os.Stderr, logger.Writer())
+}
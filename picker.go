@@ -0,0 +1,291 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// maxPickerRows keeps the picker small enough to be redrawn in place below the prompt.
+const maxPickerRows = 10
+
+type pickerKeyKind int
+
+const (
+	keyRune pickerKeyKind = iota
+	keyUp
+	keyDown
+	keyEnter
+	keyBackspace
+	keyClear
+	keyCancel
+)
+
+type pickerKey struct {
+	kind pickerKeyKind
+	r    rune
+}
+
+// picker is the state of the interactive cluster picker. It lists every cluster that can be logged in to,
+// filters them with the fuzzy query typed so far and marks the ones with a valid session as they are checked.
+type picker struct {
+	listings []clusterListing
+	query    string
+	// matches are indexes into listings, best match first
+	matches  []int
+	selected int
+	// offset is the first match shown, so that the selected one is always visible
+	offset int
+	chosen *clusterListing
+}
+
+func newPicker(listings []clusterListing) *picker {
+	p := &picker{}
+	for _, listing := range listings {
+		// only clusters with an alias can be logged in to
+		if len(listing.Aliases) > 0 {
+			p.listings = append(p.listings, listing)
+		}
+	}
+	p.filter()
+	return p
+}
+
+// fuzzyScore matches query as a case-insensitive subsequence of candidate. Lower scores are better: the score
+// counts the characters skipped before and between the matched ones, so prefixes and contiguous matches rank first.
+func fuzzyScore(query, candidate string) (int, bool) {
+	q, c := []rune(strings.ToLower(query)), []rune(strings.ToLower(candidate))
+	score, matched := 0, 0
+	for i := 0; i < len(c) && matched < len(q); i++ {
+		if c[i] == q[matched] {
+			matched++
+		} else {
+			score++
+		}
+	}
+	return score, matched == len(q)
+}
+
+func (p *picker) filter() {
+	scores := map[int]int{}
+	p.matches = p.matches[:0]
+	for i, listing := range p.listings {
+		best, found := 0, false
+		for _, candidate := range append([]string{listing.Cluster}, listing.Aliases...) {
+			if score, ok := fuzzyScore(p.query, candidate); ok && (!found || score < best) {
+				best, found = score, true
+			}
+		}
+		if found {
+			scores[i] = best
+			p.matches = append(p.matches, i)
+		}
+	}
+	sort.SliceStable(p.matches, func(i, j int) bool { return scores[p.matches[i]] < scores[p.matches[j]] })
+	p.selected, p.offset = 0, 0
+}
+
+// handle applies a key and reports whether the picker is done, either chosen or cancelled.
+func (p *picker) handle(key pickerKey) bool {
+	switch key.kind {
+	case keyRune:
+		p.query += string(key.r)
+		p.filter()
+	case keyBackspace:
+		if r := []rune(p.query); len(r) > 0 {
+			p.query = string(r[:len(r)-1])
+			p.filter()
+		}
+	case keyClear:
+		p.query = ""
+		p.filter()
+	case keyUp:
+		if p.selected > 0 {
+			p.selected--
+		}
+	case keyDown:
+		if p.selected < len(p.matches)-1 {
+			p.selected++
+		}
+	case keyEnter:
+		if len(p.matches) == 0 {
+			return false
+		}
+		p.chosen = &p.listings[p.matches[p.selected]]
+		return true
+	case keyCancel:
+		return true
+	}
+	if p.selected < p.offset {
+		p.offset = p.selected
+	} else if p.selected >= p.offset+maxPickerRows {
+		p.offset = p.selected - maxPickerRows + 1
+	}
+	return false
+}
+
+// setLoggedIn records the result of a session check, which arrive while the user is already typing.
+func (p *picker) setLoggedIn(cluster string, loggedIn bool) {
+	for i := range p.listings {
+		if p.listings[i].Cluster == cluster {
+			p.listings[i].LoggedIn = loggedIn
+		}
+	}
+}
+
+// lines renders the prompt and the visible matches, each cut to width so that none wraps.
+func (p *picker) lines(width int) []string {
+	lines := []string{"cluster> " + p.query}
+	end := p.offset + maxPickerRows
+	if end > len(p.matches) {
+		end = len(p.matches)
+	}
+	clusterWidth, aliasesWidth := 0, 0
+	for _, i := range p.matches[p.offset:end] {
+		clusterWidth = max(clusterWidth, len(p.listings[i].Cluster))
+		aliasesWidth = max(aliasesWidth, len(strings.Join(p.listings[i].Aliases, ",")))
+	}
+	for n, i := range p.matches[p.offset:end] {
+		listing := p.listings[i]
+		marker, session := "  ", ""
+		if p.offset+n == p.selected {
+			marker = "> "
+		}
+		if listing.LoggedIn {
+			session = "  logged in"
+		}
+		line := fmt.Sprintf("%s%-*s  %-*s  %s%s", marker, clusterWidth, listing.Cluster,
+			aliasesWidth, strings.Join(listing.Aliases, ","), listing.Issuer, session)
+		lines = append(lines, truncate(line, width))
+	}
+	if len(p.matches) == 0 {
+		lines = append(lines, "  no matching cluster")
+	}
+	return lines
+}
+
+func truncate(s string, width int) string {
+	if r := []rune(s); width > 0 && len(r) > width {
+		return string(r[:width])
+	}
+	return s
+}
+
+// parseKeys decodes what a terminal in raw mode sends for a key press, including arrow key escape sequences.
+func parseKeys(b []byte) []pickerKey {
+	var keys []pickerKey
+	for i := 0; i < len(b); i++ {
+		switch c := b[i]; {
+		case c == 27 && i+2 < len(b) && (b[i+1] == '[' || b[i+1] == 'O'):
+			switch b[i+2] {
+			case 'A':
+				keys = append(keys, pickerKey{kind: keyUp})
+			case 'B':
+				keys = append(keys, pickerKey{kind: keyDown})
+			}
+			i += 2
+		case c == 27, c == 3, c == 4:
+			keys = append(keys, pickerKey{kind: keyCancel})
+		case c == '\r' || c == '\n':
+			keys = append(keys, pickerKey{kind: keyEnter})
+		case c == 127 || c == 8:
+			keys = append(keys, pickerKey{kind: keyBackspace})
+		case c == 21:
+			keys = append(keys, pickerKey{kind: keyClear})
+		case c == 16:
+			keys = append(keys, pickerKey{kind: keyUp})
+		case c == 14:
+			keys = append(keys, pickerKey{kind: keyDown})
+		case c >= 32 && c < 127:
+			keys = append(keys, pickerKey{kind: keyRune, r: rune(c)})
+		}
+	}
+	return keys
+}
+
+// canPick reports whether the user can be asked to pick a cluster: the picker reads stdin and draws on stderr,
+// so both have to be a terminal. stdout doesn't, it is usually captured by the shell integration.
+func canPick() bool {
+	return isTerminal(int(os.Stdin.Fd())) && isTerminal(int(os.Stderr.Fd()))
+}
+
+// pickAlias shows the picker on stderr and returns an alias of the chosen cluster.
+// The sessions are checked in the background, as each check may have to reach the cluster. Keys are read
+// on this goroutine, so that nothing is left reading stdin once a cluster is chosen.
+func pickAlias(rawConfig map[string]*configuration, masterKubeconfig string) (string, error) {
+	p := newPicker(listClusters(rawConfig, masterKubeconfig, func(string) bool { return false }))
+	if len(p.listings) == 0 {
+		return "", errorf(exitConfig, "there are no clusters with an alias in %s", getConfigPath())
+	}
+
+	restore, err := makeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		return "", fmt.Errorf("cannot read from terminal: %w", err)
+	}
+	defer restore()
+
+	var mu sync.Mutex
+	done := false
+	draw := func() {
+		redraw(os.Stderr, p.lines(terminalWidth(int(os.Stderr.Fd()))-1))
+	}
+
+	for _, listing := range p.listings {
+		go func(listing clusterListing) {
+			loggedIn := hasSession(listing.Kubeconfig)
+			mu.Lock()
+			defer mu.Unlock()
+			if !done {
+				p.setLoggedIn(listing.Cluster, loggedIn)
+				draw()
+			}
+		}(listing)
+	}
+
+	mu.Lock()
+	draw()
+	mu.Unlock()
+	buf := make([]byte, 64)
+	for !done {
+		n, err := os.Stdin.Read(buf)
+		mu.Lock()
+		if err != nil {
+			done = true
+		}
+		for _, key := range parseKeys(buf[:n]) {
+			if done = done || p.handle(key); done {
+				break
+			}
+		}
+		if done {
+			redraw(os.Stderr, nil)
+		} else {
+			draw()
+		}
+		mu.Unlock()
+	}
+
+	if p.chosen == nil {
+		return "", errorf(exitUsage, "no cluster chosen")
+	}
+	return p.chosen.Aliases[0], nil
+}
+
+// redraw replaces what the picker drew before with lines. The cursor is left on the prompt line,
+// so the next redraw only has to clear the screen from there.
+func redraw(w io.Writer, lines []string) {
+	var b strings.Builder
+	b.WriteString("\r\x1b[J")
+	// raw mode doesn't translate \n, hence the explicit carriage returns
+	b.WriteString(strings.Join(lines, "\r\n"))
+	if len(lines) > 1 {
+		fmt.Fprintf(&b, "\x1b[%dA", len(lines)-1)
+	}
+	if len(lines) > 0 {
+		fmt.Fprintf(&b, "\r\x1b[%dC", len([]rune(lines[0])))
+	}
+	io.WriteString(w, b.String())
+}
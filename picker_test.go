@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testPicker() *picker {
+	return newPicker([]clusterListing{
+		{Cluster: "upp-prod-delivery-eu", Aliases: []string{"prod-eu"}, Issuer: "https://eu"},
+		{Cluster: "upp-prod-delivery-us", Aliases: []string{"prod-us"}, Issuer: "https://us"},
+		{Cluster: "pac-staging", Aliases: []string{"pac"}, Issuer: "https://pac"},
+		{Cluster: "no-alias"},
+	})
+}
+
+func typeQuery(p *picker, query string) {
+	for _, r := range query {
+		p.handle(pickerKey{kind: keyRune, r: r})
+	}
+}
+
+func matchedClusters(p *picker) []string {
+	var clusters []string
+	for _, i := range p.matches {
+		clusters = append(clusters, p.listings[i].Cluster)
+	}
+	return clusters
+}
+
+func TestFuzzyScore(t *testing.T) {
+	testCases := []struct {
+		query     string
+		candidate string
+		score     int
+		ok        bool
+	}{
+		{"", "anything", 0, true},
+		{"prod", "prod-eu", 0, true},
+		{"PROD", "prod-eu", 0, true},
+		{"peu", "prod-eu", 4, true},
+		{"pde", "upp-prod-delivery-eu", 8, true},
+		{"eu", "prod-us", 0, false},
+		{"prod-eu-1", "prod-eu", 0, false},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%s in %s", tc.query, tc.candidate), func(t *testing.T) {
+			score, ok := fuzzyScore(tc.query, tc.candidate)
+			assert.Equal(t, tc.ok, ok)
+			if tc.ok {
+				assert.Equal(t, tc.score, score)
+			}
+		})
+	}
+}
+
+func TestPickerFilter(t *testing.T) {
+	p := testPicker()
+	assert.Equal(t, []string{"upp-prod-delivery-eu", "upp-prod-delivery-us", "pac-staging"}, matchedClusters(p), "clusters without an alias can't be picked")
+
+	typeQuery(p, "pac")
+	assert.Equal(t, []string{"pac-staging"}, matchedClusters(p))
+
+	p.handle(pickerKey{kind: keyClear})
+	typeQuery(p, "uu")
+	assert.Equal(t, []string{"upp-prod-delivery-us", "upp-prod-delivery-eu"}, matchedClusters(p), "the closest match ranks first")
+
+	typeQuery(p, "xyz")
+	assert.Empty(t, matchedClusters(p))
+	for i := 0; i < 3; i++ {
+		p.handle(pickerKey{kind: keyBackspace})
+	}
+	assert.Equal(t, "uu", p.query)
+	assert.Len(t, p.matches, 2)
+}
+
+func TestPickerHandle(t *testing.T) {
+	p := testPicker()
+	assert.False(t, p.handle(pickerKey{kind: keyUp}))
+	assert.Equal(t, 0, p.selected)
+	assert.False(t, p.handle(pickerKey{kind: keyDown}))
+	assert.False(t, p.handle(pickerKey{kind: keyDown}))
+	assert.False(t, p.handle(pickerKey{kind: keyDown}))
+	assert.Equal(t, 2, p.selected, "the selection stops at the last match")
+
+	assert.True(t, p.handle(pickerKey{kind: keyEnter}))
+	assert.Equal(t, "pac-staging", p.chosen.Cluster)
+
+	p = testPicker()
+	typeQuery(p, "xyz")
+	assert.False(t, p.handle(pickerKey{kind: keyEnter}), "enter does nothing without a match")
+	assert.True(t, p.handle(pickerKey{kind: keyCancel}))
+	assert.Nil(t, p.chosen)
+}
+
+func TestPickerScrolls(t *testing.T) {
+	var listings []clusterListing
+	for i := 0; i < maxPickerRows+5; i++ {
+		listings = append(listings, clusterListing{Cluster: fmt.Sprintf("cluster%02d", i), Aliases: []string{fmt.Sprintf("alias%02d", i)}})
+	}
+	p := newPicker(listings)
+	for i := 0; i < maxPickerRows+2; i++ {
+		p.handle(pickerKey{kind: keyDown})
+	}
+	assert.Equal(t, 3, p.offset)
+	lines := p.lines(0)
+	assert.Len(t, lines, maxPickerRows+1)
+	assert.Contains(t, lines[len(lines)-1], "> cluster12")
+}
+
+func TestPickerLines(t *testing.T) {
+	p := testPicker()
+	p.setLoggedIn("upp-prod-delivery-us", true)
+	typeQuery(p, "prod")
+
+	assert.Equal(t, []string{
+		"cluster> prod",
+		"> upp-prod-delivery-eu  prod-eu  https://eu",
+		"  upp-prod-delivery-us  prod-us  https://us  logged in",
+	}, p.lines(0))
+	assert.Equal(t, "> upp-prod-", p.lines(11)[1], "lines are cut to the terminal width")
+
+	typeQuery(p, "xyz")
+	assert.Equal(t, []string{"cluster> prodxyz", "  no matching cluster"}, p.lines(0))
+}
+
+func TestParseKeys(t *testing.T) {
+	testCases := []struct {
+		name  string
+		input string
+		keys  []pickerKey
+	}{
+		{"text", "ab", []pickerKey{{kind: keyRune, r: 'a'}, {kind: keyRune, r: 'b'}}},
+		{"arrows", "\x1b[A\x1b[B\x1bOA", []pickerKey{{kind: keyUp}, {kind: keyDown}, {kind: keyUp}}},
+		{"ctrl-p and ctrl-n", "\x10\x0e", []pickerKey{{kind: keyUp}, {kind: keyDown}}},
+		{"enter", "\r", []pickerKey{{kind: keyEnter}}},
+		{"backspace", "\x7f\x08", []pickerKey{{kind: keyBackspace}, {kind: keyBackspace}}},
+		{"ctrl-u", "\x15", []pickerKey{{kind: keyClear}}},
+		{"escape", "\x1b", []pickerKey{{kind: keyCancel}}},
+		{"ctrl-c", "\x03", []pickerKey{{kind: keyCancel}}},
+		{"other control characters", "\x01\t", nil},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.keys, parseKeys([]byte(tc.input)))
+		})
+	}
+}
+
+func TestRedraw(t *testing.T) {
+	var out bytes.Buffer
+	redraw(&out, []string{"cluster> pr", "> prod"})
+	assert.Equal(t, "\r\x1b[Jcluster> pr\r\n> prod\x1b[1A\r\x1b[11C", out.String())
+
+	out.Reset()
+	redraw(&out, nil)
+	assert.Equal(t, "\r\x1b[J", out.String(), "clearing leaves nothing behind")
+}
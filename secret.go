@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// secretSource is where the client secret of a cluster's Dex client is read from.
+type secretSource interface {
+	secret() (string, error)
+	// String describes the source in error messages, without revealing the secret.
+	String() string
+}
+
+// loginSecretSource returns the secret source configured for the cluster, or nil if there is none.
+// Only one should be configured, "config validate" reports clusters with more.
+func (c *configuration) loginSecretSource() secretSource {
+	switch {
+	case c.LoginSecretEnv != "":
+		return envSecret(c.LoginSecretEnv)
+	case c.LoginSecretFile != "":
+		return fileSecret(c.LoginSecretFile)
+	case c.LoginSecretCommand != "":
+		return commandSecret(c.LoginSecretCommand)
+	case len(c.LoginSecretKeyring) > 0:
+		return keyringSecret(c.LoginSecretKeyring)
+	case c.LoginSecret != "":
+		return plaintextSecret(c.LoginSecret)
+	default:
+		return nil
+	}
+}
+
+func (c *configuration) loginSecretSourceCount() int {
+	count := 0
+	for _, configured := range []bool{c.LoginSecretEnv != "", c.LoginSecretFile != "", c.LoginSecretCommand != "",
+		len(c.LoginSecretKeyring) > 0, c.LoginSecret != ""} {
+		if configured {
+			count++
+		}
+	}
+	return count
+}
+
+type envSecret string
+
+func (s envSecret) secret() (string, error) {
+	secret := os.Getenv(string(s))
+	if secret == "" {
+		return "", fmt.Errorf("%s is not set", string(s))
+	}
+	return secret, nil
+}
+
+func (s envSecret) String() string {
+	return "environment variable " + string(s)
+}
+
+type fileSecret string
+
+func (s fileSecret) path() string {
+	path := string(s)
+	if strings.HasPrefix(path, "~/") {
+		path = filepath.Join(os.Getenv("HOME"), path[2:])
+	}
+	return path
+}
+
+func (s fileSecret) secret() (string, error) {
+	path := s.path()
+	if info, err := os.Stat(path); err == nil && info.Mode().Perm()&0077 != 0 {
+		fmt.Fprintf(os.Stderr, "warning: %s is readable by other users, it should be 0600\n", path)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	secret := strings.TrimSpace(string(data))
+	if secret == "" {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+	return secret, nil
+}
+
+func (s fileSecret) String() string {
+	return "file " + s.path()
+}
+
+// commandSecret is a shell command printing the secret, e.g. "pass show dex/kubectl-login".
+// It keeps the terminal, so password managers can prompt for their passphrase.
+type commandSecret string
+
+func (s commandSecret) secret() (string, error) {
+	cmd := startCommand("sh", "-c", string(s))
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	// like pass, commands may print more lines after the secret
+	secret := strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0])
+	if secret == "" {
+		return "", errors.New("the command printed no secret")
+	}
+	return secret, nil
+}
+
+func (s commandSecret) String() string {
+	return fmt.Sprintf("command %q", string(s))
+}
+
+type plaintextSecret string
+
+// secret warns every time, as the plaintext loginSecret is only kept for existing config files.
+func (s plaintextSecret) secret() (string, error) {
+	fmt.Fprintf(os.Stderr, "warning: the login secret is stored in plaintext in %s, "+
+		"consider loginSecretKeyring, loginSecretCommand, loginSecretFile or loginSecretEnv instead\n", getConfigPath())
+	return string(s), nil
+}
+
+func (s plaintextSecret) String() string {
+	return "loginSecret"
+}
+
+// keyringSecret holds the attributes of an item in the Secret Service (GNOME Keyring, KWallet, KeePassXC, ...),
+// as stored by e.g. "secret-tool store --label=kubectl-login service kubectl-login cluster prod".
+// It is only supported on Linux, see secret_linux.go.
+type keyringSecret map[string]string
+
+func (s keyringSecret) String() string {
+	attributes := make([]string, 0, len(s))
+	for name, value := range s {
+		attributes = append(attributes, name+"="+value)
+	}
+	sort.Strings(attributes)
+	return "keyring item " + strings.Join(attributes, " ")
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	secretServiceName      = "org.freedesktop.secrets"
+	secretServicePath      = "/org/freedesktop/secrets"
+	secretServiceInterface = "org.freedesktop.Secret.Service"
+	secretPromptInterface  = "org.freedesktop.Secret.Prompt"
+	secretPromptTimeout    = 2 * time.Minute
+)
+
+// secretServiceSecret is the Secret struct of the Secret Service API, (oayays) on the wire.
+type secretServiceSecret struct {
+	Session     dbus.ObjectPath
+	Parameters  []byte
+	Value       []byte
+	ContentType string
+}
+
+func (s keyringSecret) secret() (string, error) {
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		return "", fmt.Errorf("cannot connect to the session bus: %v", err)
+	}
+	service := conn.Object(secretServiceName, secretServicePath)
+
+	var unlocked, locked []dbus.ObjectPath
+	if err := service.Call(secretServiceInterface+".SearchItems", 0, map[string]string(s)).Store(&unlocked, &locked); err != nil {
+		return "", fmt.Errorf("cannot search the keyring: %v", err)
+	}
+	if len(unlocked) == 0 && len(locked) > 0 {
+		if unlocked, err = unlockItems(conn, service, locked); err != nil {
+			return "", err
+		}
+	}
+	if len(unlocked) == 0 {
+		return "", errors.New("no matching item in the keyring")
+	}
+
+	// the secret is sent unencrypted over the session bus, which is private to the user
+	var output dbus.Variant
+	var session dbus.ObjectPath
+	if err := service.Call(secretServiceInterface+".OpenSession", 0, "plain", dbus.MakeVariant("")).Store(&output, &session); err != nil {
+		return "", fmt.Errorf("cannot open a keyring session: %v", err)
+	}
+	defer conn.Object(secretServiceName, session).Call("org.freedesktop.Secret.Session.Close", 0)
+
+	var secret secretServiceSecret
+	if err := conn.Object(secretServiceName, unlocked[0]).Call("org.freedesktop.Secret.Item.GetSecret", 0, session).Store(&secret); err != nil {
+		return "", fmt.Errorf("cannot read the keyring item: %v", err)
+	}
+	value := string(bytes.TrimSpace(secret.Value))
+	if value == "" {
+		return "", errors.New("the keyring item is empty")
+	}
+	return value, nil
+}
+
+// unlockItems asks the Secret Service to unlock the items, which may prompt the user for the keyring password.
+func unlockItems(conn *dbus.Conn, service dbus.BusObject, locked []dbus.ObjectPath) ([]dbus.ObjectPath, error) {
+	var unlocked []dbus.ObjectPath
+	var prompt dbus.ObjectPath
+	if err := service.Call(secretServiceInterface+".Unlock", 0, locked).Store(&unlocked, &prompt); err != nil {
+		return nil, fmt.Errorf("cannot unlock the keyring: %v", err)
+	}
+	if prompt == "/" {
+		return unlocked, nil
+	}
+
+	if err := conn.AddMatchSignal(dbus.WithMatchObjectPath(prompt), dbus.WithMatchInterface(secretPromptInterface), dbus.WithMatchMember("Completed")); err != nil {
+		return nil, fmt.Errorf("cannot unlock the keyring: %v", err)
+	}
+	signals := make(chan *dbus.Signal, 1)
+	conn.Signal(signals)
+	defer conn.RemoveSignal(signals)
+
+	if err := conn.Object(secretServiceName, prompt).Call(secretPromptInterface+".Prompt", 0, "").Err; err != nil {
+		return nil, fmt.Errorf("cannot unlock the keyring: %v", err)
+	}
+	timeout := time.After(secretPromptTimeout)
+	for {
+		select {
+		case signal := <-signals:
+			if signal.Path != prompt || len(signal.Body) < 2 {
+				continue
+			}
+			if dismissed, _ := signal.Body[0].(bool); dismissed {
+				return nil, errors.New("unlocking the keyring was dismissed")
+			}
+			var result []dbus.ObjectPath
+			if err := dbus.Store(signal.Body[1:], &result); err != nil {
+				return nil, fmt.Errorf("cannot unlock the keyring: %v", err)
+			}
+			return result, nil
+		case <-timeout:
+			return nil, errors.New("timed out waiting for the keyring to be unlocked")
+		}
+	}
+}
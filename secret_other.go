@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// The Secret Service is a D-Bus API, which is only available on Linux.
+func (s keyringSecret) secret() (string, error) {
+	return "", errors.New("the keyring is not supported on this platform, use loginSecretCommand instead")
+}
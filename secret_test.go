@@ -0,0 +1,95 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoginSecretSource(t *testing.T) {
+	var testCases = []struct {
+		config         *configuration
+		expectedSource secretSource
+	}{
+		{
+			config:         &configuration{},
+			expectedSource: nil,
+		},
+		{
+			config:         &configuration{LoginSecret: "terces"},
+			expectedSource: plaintextSecret("terces"),
+		},
+		{
+			config:         &configuration{LoginSecretEnv: "DEX_SECRET"},
+			expectedSource: envSecret("DEX_SECRET"),
+		},
+		{
+			config:         &configuration{LoginSecretFile: "~/.dex-secret"},
+			expectedSource: fileSecret("~/.dex-secret"),
+		},
+		{
+			config:         &configuration{LoginSecretCommand: "pass show dex"},
+			expectedSource: commandSecret("pass show dex"),
+		},
+		{
+			config:         &configuration{LoginSecretKeyring: map[string]string{"service": "kubectl-login"}},
+			expectedSource: keyringSecret{"service": "kubectl-login"},
+		},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expectedSource, tc.config.loginSecretSource())
+	}
+}
+
+func TestSecretSources(t *testing.T) {
+	dir, _ := ioutil.TempDir(os.TempDir(), "secret")
+	defer os.RemoveAll(dir)
+	secretFile := filepath.Join(dir, "secret")
+	ioutil.WriteFile(secretFile, []byte("file-secret\n"), 0600)
+	emptyFile := filepath.Join(dir, "empty")
+	ioutil.WriteFile(emptyFile, nil, 0600)
+	os.Setenv("KUBECTL_LOGIN_TEST_SECRET", "env-secret")
+	defer os.Unsetenv("KUBECTL_LOGIN_TEST_SECRET")
+
+	var testCases = []struct {
+		source         secretSource
+		expectedSecret string
+		expectError    bool
+	}{
+		{source: envSecret("KUBECTL_LOGIN_TEST_SECRET"), expectedSecret: "env-secret"},
+		{source: envSecret("KUBECTL_LOGIN_TEST_UNSET"), expectError: true},
+		{source: fileSecret(secretFile), expectedSecret: "file-secret"},
+		{source: fileSecret(emptyFile), expectError: true},
+		{source: fileSecret(filepath.Join(dir, "missing")), expectError: true},
+		{source: commandSecret("printf 'command-secret\\nurl: https://dex.ft.com\\n'"), expectedSecret: "command-secret"},
+		{source: commandSecret("exit 1"), expectError: true},
+		{source: commandSecret("true"), expectError: true},
+		{source: plaintextSecret("terces"), expectedSecret: "terces"},
+	}
+	for _, tc := range testCases {
+		secret, err := tc.source.secret()
+		if tc.expectError {
+			assert.Error(t, err, tc.source.String())
+		} else {
+			assert.NoError(t, err, tc.source.String())
+			assert.Equal(t, tc.expectedSecret, secret, tc.source.String())
+		}
+	}
+}
+
+func TestFileSecretHome(t *testing.T) {
+	originalHome := os.Getenv("HOME")
+	defer os.Setenv("HOME", originalHome)
+	os.Setenv("HOME", "/home/jane")
+
+	assert.Equal(t, "/home/jane/.dex-secret", fileSecret("~/.dex-secret").path())
+	assert.Equal(t, "/etc/dex-secret", fileSecret("/etc/dex-secret").path())
+}
+
+func TestKeyringSecretString(t *testing.T) {
+	source := keyringSecret{"service": "kubectl-login", "cluster": "prod"}
+	assert.Equal(t, "keyring item cluster=prod service=kubectl-login", source.String())
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// sessionState is the result of checking the session held by a per-cluster kubeconfig.
+type sessionState string
+
+const (
+	// sessionNone means there are no kubectl-login credentials to check.
+	sessionNone         sessionState = "none"
+	sessionValid        sessionState = "valid"
+	sessionExpired      sessionState = "expired"
+	sessionUnauthorized sessionState = "unauthorized"
+	sessionUnreachable  sessionState = "unreachable"
+)
+
+// sessionProbeTimeout keeps the check short when the API server is behind a VPN that is down.
+const sessionProbeTimeout = 3 * time.Second
+
+const selfSubjectReviewPath = "/apis/authentication.k8s.io/v1/selfsubjectreviews"
+
+// checkSession checks the credentials of the current context of a per-cluster kubeconfig. The expiry of the token
+// is checked locally first, so an expired session is found without reaching the cluster. Only then is the token
+// sent to the API server, which tells a rejected token apart from an API server that cannot be reached.
+// The error says why the session isn't valid.
+func checkSession(ctx context.Context, clusterKubeconfig string) (sessionState, error) {
+	k, err := loadKubeconfig(clusterKubeconfig)
+	if err != nil {
+		return sessionNone, err
+	}
+	var kubeContext *kubeContext
+	for i := range k.Contexts {
+		if k.Contexts[i].Name == k.CurrentContext {
+			kubeContext = &k.Contexts[i].Context
+		}
+	}
+	if kubeContext == nil {
+		return sessionNone, fmt.Errorf("no current context in %s", clusterKubeconfig)
+	}
+	var cluster *kubeCluster
+	for i := range k.Clusters {
+		if k.Clusters[i].Name == kubeContext.Cluster {
+			cluster = &k.Clusters[i].Cluster
+		}
+	}
+	if cluster == nil || cluster.Server == "" {
+		return sessionNone, fmt.Errorf("no server for cluster %s in %s", kubeContext.Cluster, clusterKubeconfig)
+	}
+
+	token, err := storedIdToken(kubeContext.Cluster, kubeContext.User, clusterKubeconfig)
+	if err != nil {
+		return sessionNone, err
+	}
+	// tokens which aren't a jwt, e.g. service account tokens, can only be checked by the API server
+	if expiry, err := idTokenExpiry(token); err == nil && !time.Now().Before(expiry) {
+		return sessionExpired, fmt.Errorf("the token expired at %s", expiry.Local().Format(time.RFC1123))
+	}
+
+	client, err := newClusterClient(cluster, filepath.Dir(clusterKubeconfig))
+	if err != nil {
+		return sessionUnreachable, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, sessionProbeTimeout)
+	defer cancel()
+	return probeSession(ctx, client, cluster.Server, token)
+}
+
+// probeSession asks the API server who the token belongs to with a SelfSubjectReview. API servers older than
+// 1.28 don't serve it, the token is then sent to the discovery endpoint instead. A 403 means the token was
+// accepted, only the request wasn't authorized.
+func probeSession(ctx context.Context, client *http.Client, server, token string) (sessionState, error) {
+	body := `{"apiVersion":"authentication.k8s.io/v1","kind":"SelfSubjectReview"}`
+	status, err := sendProbe(ctx, client, http.MethodPost, strings.TrimSuffix(server, "/")+selfSubjectReviewPath, token, body)
+	if err == nil && status == http.StatusNotFound {
+		status, err = sendProbe(ctx, client, http.MethodGet, strings.TrimSuffix(server, "/")+"/api", token, "")
+	}
+	switch {
+	case err != nil:
+		return sessionUnreachable, fmt.Errorf("cannot reach the API server %s: %v", server, err)
+	case status == http.StatusUnauthorized:
+		return sessionUnauthorized, fmt.Errorf("the API server %s rejected the token", server)
+	case status < 300 || status == http.StatusForbidden:
+		return sessionValid, nil
+	default:
+		return sessionUnreachable, fmt.Errorf("the API server %s answered with status %d", server, status)
+	}
+}
+
+func sendProbe(ctx context.Context, client *http.Client, method, url, token, body string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, strings.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// newClusterClient trusts the certificate authority of the cluster, like kubectl does. A relative
+// certificate-authority path is relative to the directory of the kubeconfig.
+func newClusterClient(cluster *kubeCluster, kubeconfigDir string) (*http.Client, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: cluster.InsecureSkipTLSVerify}
+	var caData []byte
+	switch {
+	case cluster.CertificateAuthorityData != "":
+		data, err := base64.StdEncoding.DecodeString(cluster.CertificateAuthorityData)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate-authority-data: %v", err)
+		}
+		caData = data
+	case cluster.CertificateAuthority != "":
+		path := cluster.CertificateAuthority
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(kubeconfigDir, path)
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read certificate-authority: %v", err)
+		}
+		caData = data
+	}
+	if caData != nil {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caData) {
+			return nil, errors.New("no certificate in the certificate authority of the cluster")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: transport}, nil
+}
+
+// isLoggedIn reports whether login can be skipped. A token that hasn't expired is kept when the API server
+// cannot be reached, as logging in again wouldn't make it reachable.
+func isLoggedIn(cluster, clusterKubeconfig string) bool {
+	state, err := checkSession(context.Background(), clusterKubeconfig)
+	switch state {
+	case sessionValid:
+		return true
+	case sessionUnreachable:
+		fmt.Fprintf(os.Stderr, "warning: keeping the session of %s: %v\n", cluster, err)
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeSessionKubeconfig writes a per-cluster kubeconfig for the API server, whose current context uses the token.
+func writeSessionKubeconfig(t *testing.T, server *httptest.Server, token string) string {
+	caData := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	k := &kubeconfig{
+		Clusters: []namedCluster{{Name: "config1", Cluster: kubeCluster{
+			Server:                   server.URL,
+			CertificateAuthorityData: base64.StdEncoding.EncodeToString(caData),
+		}}},
+		Contexts:       []namedContext{{Name: "config1", Context: kubeContext{Cluster: "config1", User: clientID}}},
+		CurrentContext: "config1",
+		Users:          []namedUser{{Name: clientID, User: kubeUser{Token: token}}},
+	}
+	path := writeTempKubeconfig(t, "")
+	if err := k.save(path); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// newTestAPIServer answers the SelfSubjectReview with reviewStatus and the discovery endpoint with discoveryStatus.
+func newTestAPIServer(t *testing.T, token string, reviewStatus, discoveryStatus int) *httptest.Server {
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == selfSubjectReviewPath:
+			w.WriteHeader(reviewStatus)
+		case r.Method == http.MethodGet && r.URL.Path == "/api":
+			w.WriteHeader(discoveryStatus)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestCheckSession(t *testing.T) {
+	validToken := signTestToken(t, validTestClaims())
+	expiredClaims := validTestClaims()
+	expiredClaims["exp"] = time.Now().Add(-time.Minute).Unix()
+	expiredToken := signTestToken(t, expiredClaims)
+
+	var testCases = []struct {
+		description     string
+		serverToken     string
+		storedToken     string
+		reviewStatus    int
+		discoveryStatus int
+		expectedState   sessionState
+	}{
+		{
+			description:   "the API server accepts the token",
+			serverToken:   validToken,
+			storedToken:   validToken,
+			reviewStatus:  http.StatusCreated,
+			expectedState: sessionValid,
+		},
+		{
+			description:   "accepted but not allowed to review itself",
+			serverToken:   validToken,
+			storedToken:   validToken,
+			reviewStatus:  http.StatusForbidden,
+			expectedState: sessionValid,
+		},
+		{
+			description:     "API servers without SelfSubjectReview",
+			serverToken:     validToken,
+			storedToken:     validToken,
+			reviewStatus:    http.StatusNotFound,
+			discoveryStatus: http.StatusOK,
+			expectedState:   sessionValid,
+		},
+		{
+			description:   "the API server rejects the token",
+			serverToken:   "another-token",
+			storedToken:   validToken,
+			expectedState: sessionUnauthorized,
+		},
+		{
+			description:   "expired tokens are not sent",
+			serverToken:   expiredToken,
+			storedToken:   expiredToken,
+			reviewStatus:  http.StatusCreated,
+			expectedState: sessionExpired,
+		},
+		{
+			description:   "tokens which are not a jwt",
+			serverToken:   "service-account-token",
+			storedToken:   "service-account-token",
+			reviewStatus:  http.StatusCreated,
+			expectedState: sessionValid,
+		},
+		{
+			description:   "server errors",
+			serverToken:   validToken,
+			storedToken:   validToken,
+			reviewStatus:  http.StatusServiceUnavailable,
+			expectedState: sessionUnreachable,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			server := newTestAPIServer(t, tc.serverToken, tc.reviewStatus, tc.discoveryStatus)
+			defer server.Close()
+			path := writeSessionKubeconfig(t, server, tc.storedToken)
+			defer os.Remove(path)
+
+			state, err := checkSession(context.Background(), path)
+			assert.Equal(t, tc.expectedState, state)
+			if tc.expectedState == sessionValid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestCheckSessionUnreachable(t *testing.T) {
+	token := signTestToken(t, validTestClaims())
+	server := newTestAPIServer(t, token, http.StatusCreated, http.StatusOK)
+	path := writeSessionKubeconfig(t, server, token)
+	defer os.Remove(path)
+	server.Close()
+
+	state, err := checkSession(context.Background(), path)
+	assert.Equal(t, sessionUnreachable, state)
+	assert.Error(t, err)
+	assert.True(t, isLoggedIn("config1", path), "a token that hasn't expired is kept while the API server is unreachable")
+}
+
+func TestCheckSessionUntrustedServer(t *testing.T) {
+	token := signTestToken(t, validTestClaims())
+	server := newTestAPIServer(t, token, http.StatusCreated, http.StatusOK)
+	defer server.Close()
+	path := writeSessionKubeconfig(t, server, token)
+	defer os.Remove(path)
+	updateKubeconfig(path, func(k *kubeconfig) {
+		k.Clusters[0].Cluster.CertificateAuthorityData = ""
+	})
+
+	state, _ := checkSession(context.Background(), path)
+	assert.Equal(t, sessionUnreachable, state, "the system roots don't trust the test server")
+}
+
+func TestCheckSessionNotLoggedIn(t *testing.T) {
+	state, err := checkSession(context.Background(), "does-not-exist")
+	assert.Equal(t, sessionNone, state)
+	assert.Error(t, err)
+	assert.False(t, isLoggedIn("config1", "does-not-exist"))
+
+	path := writeTempKubeconfig(t, testKubeconfig)
+	defer os.Remove(path)
+	state, _ = checkSession(context.Background(), path)
+	assert.Equal(t, sessionNone, state, "the current context has no cluster")
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+)
+
+const shellInitCommandName = "shell-init"
+
+// shellInitData fills in the shell integration templates. The subcommand lists are derived from the
+// command names, so the generated functions don't drift from the binary like the hand-written wrappers did.
+type shellInitData struct {
+	// Name is the shell function, kubectl-login by default so that it wraps the binary transparently.
+	Name string
+	// Passthrough are the subcommands whose output isn't a kubeconfig path.
+	Passthrough []string
+	// AliasCommands take an alias as their argument.
+	AliasCommands []string
+	// Commands are completed next to the aliases.
+	Commands []string
+}
+
+func (d shellInitData) Words(words []string, separator string) string {
+	return strings.Join(words, separator)
+}
+
+var shellInitTemplates = map[string]string{
+	"bash": bashInit,
+	"zsh":  zshInit,
+	"fish": fishInit,
+}
+
+// The bash function is also valid zsh, only the completion differs.
+const posixFunction = `{{.Name}}() {
+  case "$1" in
+    {{.Words .Passthrough "|"}})
+      command kubectl-login "$@"
+      return
+      ;;
+    logout)
+      local __kubectl_login_master
+      __kubectl_login_master="$(command kubectl-login "$@")" || return
+      export KUBECONFIG="$__kubectl_login_master"
+      echo "Using KUBECONFIG=$KUBECONFIG" >&2
+      return
+      ;;
+  esac
+  local __kubectl_login_env
+  __kubectl_login_env="$(command kubectl-login "$@" --output env)" || return
+  eval "$__kubectl_login_env"
+  echo "Using KUBECONFIG=$KUBECONFIG" >&2
+}
+`
+
+const bashInit = posixFunction + `
+_kubectl_login_complete() {
+  local words=""
+  if [ "$COMP_CWORD" -eq 1 ]; then
+    words="$(command kubectl-login list --aliases 2>/dev/null) {{.Words .Commands " "}}"
+  elif [ "$COMP_CWORD" -eq 2 ]; then
+    case "${COMP_WORDS[1]}" in
+      {{.Words .AliasCommands "|"}}) words="$(command kubectl-login list --aliases 2>/dev/null)" ;;
+    esac
+  fi
+  COMPREPLY=($(compgen -W "$words" -- "${COMP_WORDS[COMP_CWORD]}"))
+}
+complete -F _kubectl_login_complete {{.Name}}
+`
+
+const zshInit = posixFunction + `
+_kubectl_login_complete() {
+  local -a aliases
+  aliases=(${(f)"$(command kubectl-login list --aliases 2>/dev/null)"})
+  if (( CURRENT == 2 )); then
+    compadd -- $aliases {{.Words .Commands " "}}
+  elif (( CURRENT == 3 )) && [[ $words[2] == ({{.Words .AliasCommands "|"}}) ]]; then
+    compadd -- $aliases
+  fi
+}
+if (( $+functions[compdef] )); then
+  compdef _kubectl_login_complete {{.Name}}
+fi
+`
+
+const fishInit = `function {{.Name}}
+    switch "$argv[1]"
+        case {{.Words .Passthrough " "}}
+            command kubectl-login $argv
+            return
+        case logout
+            set -l master (command kubectl-login $argv); or return
+            set -gx KUBECONFIG $master
+            echo "Using KUBECONFIG=$KUBECONFIG" >&2
+            return
+    end
+    set -l login_env (command kubectl-login $argv --output env); or return
+    eval $login_env
+    echo "Using KUBECONFIG=$KUBECONFIG" >&2
+end
+
+complete -c {{.Name}} -f
+complete -c {{.Name}} -n '__fish_use_subcommand' -a '(command kubectl-login list --aliases 2>/dev/null) {{.Words .Commands " "}}'
+complete -c {{.Name}} -n '__fish_seen_subcommand_from {{.Words .AliasCommands " "}}' -a '(command kubectl-login list --aliases 2>/dev/null)'
+`
+
+// shellInitCommand implements "kubectl-login shell-init bash|zsh|fish", printing a shell function that logs in
+// and sets KUBECONFIG in the current shell, e.g. eval "$(kubectl-login shell-init bash)" in ~/.bashrc.
+func shellInitCommand(args []string) error {
+	flags := flag.NewFlagSet(shellInitCommandName, flag.ExitOnError)
+	name := flags.String("name", "kubectl-login", "name of the shell function")
+	args = parseInterleaved(flags, args)
+
+	if len(args) != 1 {
+		return errorf(exitUsage, "usage: kubectl-login %s bash|zsh|fish", shellInitCommandName)
+	}
+	return writeShellInit(os.Stdout, args[0], *name)
+}
+
+func writeShellInit(w io.Writer, shell, name string) error {
+	text, ok := shellInitTemplates[shell]
+	if !ok {
+		return errorf(exitUsage, "unsupported shell %s, use bash, zsh or fish", shell)
+	}
+	if name == "" || strings.ContainsAny(name, " \t\n'\"$;|&()<>`\\") {
+		return errorf(exitUsage, "invalid function name %q", name)
+	}
+	data := shellInitData{
+		Name:          name,
+		Passthrough:   []string{getTokenCommandName, statusCommandName, whoamiCommandName, listCommandName, configCommandName, shellInitCommandName, syncCommandName},
+		AliasCommands: []string{loginCommandName, statusCommandName, whoamiCommandName, logoutCommandName},
+		Commands:      []string{loginCommandName, statusCommandName, whoamiCommandName, listCommandName, logoutCommandName, configCommandName, syncCommandName},
+	}
+	return template.Must(template.New(shell).Parse(text)).Execute(w, data)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeKubectlLogin answers like kubectl-login: the env line for a login, the master kubeconfig for a logout.
+const fakeKubectlLogin = `#!/bin/sh
+case "$1" in
+  logout) echo "/home/jane/kube config" ;;
+  list) echo alias1; echo alias2 ;;
+  fail) exit 4 ;;
+  *) echo "export KUBECONFIG='/home/jane/kube config_$1'" ;;
+esac
+`
+
+func TestWriteShellInit(t *testing.T) {
+	for _, shell := range []string{"bash", "zsh", "fish"} {
+		var out bytes.Buffer
+		assert.NoError(t, writeShellInit(&out, shell, "k8s-login"), shell)
+		assert.Contains(t, out.String(), "k8s-login", shell)
+		assert.Contains(t, out.String(), "command kubectl-login list --aliases", shell)
+		assert.Contains(t, out.String(), "--output env", shell)
+	}
+
+	err := writeShellInit(ioutil.Discard, "powershell", "kubectl-login")
+	assert.Equal(t, exitUsage, exitCodeOf(err))
+	err = writeShellInit(ioutil.Discard, "bash", "k8s; rm -rf ~")
+	assert.Equal(t, exitUsage, exitCodeOf(err))
+}
+
+func TestShellInitBash(t *testing.T) {
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash is not installed")
+	}
+	dir, _ := ioutil.TempDir(os.TempDir(), "shell-init")
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "kubectl-login"), []byte(fakeKubectlLogin), 0755)
+
+	var init bytes.Buffer
+	assert.NoError(t, writeShellInit(&init, "bash", "kubectl-login"))
+	ioutil.WriteFile(filepath.Join(dir, "init.bash"), init.Bytes(), 0644)
+
+	var testCases = []struct {
+		script         string
+		expectedOutput string
+	}{
+		{
+			script:         `kubectl-login prod && printf %s "$KUBECONFIG"`,
+			expectedOutput: "/home/jane/kube config_prod",
+		},
+		{
+			script:         `kubectl-login logout prod && printf %s "$KUBECONFIG"`,
+			expectedOutput: "/home/jane/kube config",
+		},
+		{
+			script:         `KUBECONFIG=unchanged; kubectl-login fail; printf '%s %s' "$?" "$KUBECONFIG"`,
+			expectedOutput: "4 unchanged",
+		},
+		{
+			script:         `COMP_WORDS=(kubectl-login status al); COMP_CWORD=2; _kubectl_login_complete; printf '%s ' "${COMPREPLY[@]}"`,
+			expectedOutput: "alias1 alias2 ",
+		},
+	}
+	for _, tc := range testCases {
+		cmd := exec.Command(bash, "-c", `source "$0" && `+tc.script, filepath.Join(dir, "init.bash"))
+		cmd.Env = append(os.Environ(), "PATH="+dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+		output, err := cmd.Output()
+		assert.NoError(t, err, tc.script)
+		assert.Equal(t, tc.expectedOutput, string(output), tc.script)
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	loginCommandName = "login"
+	// crossClientScopePrefix asks Dex for an id token whose audience is another client,
+	// see https://dexidp.io/docs/custom-scopes-claims-clients/#cross-client-trust-and-authorized-party
+	crossClientScopePrefix = "audience:server:client_id:"
+)
+
+// crossClientScopes returns the scopes of an id token accepted by every audience, or none if they are all clientID.
+// Dex leaves the requesting client out of the audience unless it asks for itself too, which it has to,
+// as the token is verified and refreshed as clientID.
+func crossClientScopes(clientID string, audiences []string) []string {
+	seen := map[string]bool{clientID: true}
+	var scopes []string
+	for _, audience := range audiences {
+		if !seen[audience] {
+			seen[audience] = true
+			scopes = append(scopes, crossClientScopePrefix+audience)
+		}
+	}
+	if len(scopes) == 0 {
+		return nil
+	}
+	sort.Strings(scopes)
+	return append([]string{crossClientScopePrefix + clientID}, scopes...)
+}
+
+// ssoGroup is a set of clusters sharing an issuer and a client, which a single login covers.
+type ssoGroup struct {
+	issuer   string
+	clientID string
+	// clusters are sorted, the first one decides how to log in
+	clusters  []string
+	audiences []string
+}
+
+func sameIssuer(a, b string) bool {
+	return strings.TrimSuffix(a, "/") == strings.TrimSuffix(b, "/")
+}
+
+// ssoGroups groups the clusters with an alias by issuer and client. If issuer is set, only its clusters are kept.
+func ssoGroups(rawConfig map[string]*configuration, issuer string) []*ssoGroup {
+	clusters := make([]string, 0, len(rawConfig))
+	for cluster := range rawConfig {
+		clusters = append(clusters, cluster)
+	}
+	sort.Strings(clusters)
+
+	var groups []*ssoGroup
+	byKey := map[string]*ssoGroup{}
+	for _, cluster := range clusters {
+		config := rawConfig[cluster]
+		if len(config.Aliases) == 0 || (issuer != "" && !sameIssuer(config.Issuer, issuer)) {
+			continue
+		}
+		key := strings.TrimSuffix(config.Issuer, "/") + " " + config.getClientID()
+		group, ok := byKey[key]
+		if !ok {
+			group = &ssoGroup{issuer: config.Issuer, clientID: config.getClientID()}
+			byKey[key] = group
+			groups = append(groups, group)
+		}
+		group.clusters = append(group.clusters, cluster)
+		group.audiences = append(group.audiences, config.getAudience())
+	}
+	return groups
+}
+
+// loginConfig is the configuration of the group's first cluster, with the scopes of all of them.
+func (g *ssoGroup) loginConfig(rawConfig map[string]*configuration) *configuration {
+	config := *rawConfig[g.clusters[0]]
+	var scopes []string
+	seen := map[string]bool{}
+	for _, cluster := range g.clusters {
+		for _, scope := range rawConfig[cluster].getBaseScopes() {
+			if !seen[scope] {
+				seen[scope] = true
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+	config.Scopes = append(scopes, crossClientScopes(g.clientID, g.audiences)...)
+	config.Audience = ""
+	return &config
+}
+
+// hasAudiences reports whether the id token is accepted by every audience, so that its refresh token can be reused.
+func hasAudiences(rawIdToken string, audiences []string) bool {
+	var claims idTokenClaims
+	if err := decodeIdTokenClaims(rawIdToken, &claims); err != nil {
+		return false
+	}
+	for _, audience := range audiences {
+		if !contains(claims.Audience, audience) {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(values []string, s string) bool {
+	for _, value := range values {
+		if value == s {
+			return true
+		}
+	}
+	return false
+}
+
+// loginSingleSignOn implements "kubectl-login login --all" and "kubectl-login login --issuer <url>". It logs in once
+// per issuer and client and writes the tokens into the per-cluster kubeconfig of every cluster sharing them.
+// A failed group doesn't stop the others from being logged in.
+func loginSingleSignOn(opts *options, args []string) error {
+	if len(args) > 0 {
+		return errorf(exitUsage, "--all and --issuer log in to several clusters and take no alias")
+	}
+	rawConfig, err := getRawConfig()
+	if err != nil {
+		return err
+	}
+	groups := ssoGroups(rawConfig, opts.issuer)
+	if len(groups) == 0 {
+		if opts.issuer != "" {
+			return errorf(exitConfig, "there are no clusters with an alias and issuer %s in %s", opts.issuer, getConfigPath())
+		}
+		return errorf(exitConfig, "there are no clusters with an alias in %s", getConfigPath())
+	}
+
+	masterKubeconfig, err := requireMasterConfig()
+	if err != nil {
+		return err
+	}
+	var results []*loginResult
+	var failed []string
+	var firstErr error
+	for _, group := range groups {
+		groupResults, err := loginGroup(context.Background(), opts, rawConfig, masterKubeconfig, group)
+		results = append(results, groupResults...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot log in to %s: %v\n", group.issuer, err)
+			failed = append(failed, group.issuer)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	if err := writeLoginResults(os.Stdout, opts.output, results); err != nil && firstErr == nil {
+		return fmt.Errorf("cannot write login result: %w", err)
+	}
+	if firstErr != nil {
+		return errorf(exitCodeOf(firstErr), "login failed for %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// loginGroup skips the clusters which already have a valid session and logs in to the others with a single set of tokens.
+// The tokens of several clusters are cached once for all of them, and their kubeconfigs only get the id token, so that
+// refreshing the session of one cluster doesn't spend the refresh token of the others. kubectl-login refreshes them
+// on the next login, or on every get-token with execCredential.
+func loginGroup(ctx context.Context, opts *options, rawConfig map[string]*configuration, masterKubeconfig string, group *ssoGroup) ([]*loginResult, error) {
+	var results, pending []*loginResult
+	for _, cluster := range group.clusters {
+		result := &loginResult{Cluster: cluster, Kubeconfig: getClusterConfig(masterKubeconfig, cluster)}
+		results = append(results, result)
+		syncBeforeLogin(masterKubeconfig, cluster, rawConfig[cluster])
+		if isLoggedIn(cluster, result.Kubeconfig) {
+			result.LoggedIn = true
+		} else {
+			pending = append(pending, result)
+		}
+	}
+	if len(pending) == 0 {
+		return results, nil
+	}
+
+	config := group.loginConfig(rawConfig)
+	if config.PKCE && !config.Loopback {
+		return results, errorf(exitConfig, "pkce is enabled for %s but requires loopback to be enabled too", group.clusters[0])
+	}
+
+	// the clusters share the client, so the previous session of any of them will do,
+	// as long as its token was issued for every audience
+	var previousTokens *cachedTokens
+	for _, result := range pending {
+		tokens := loadPreviousTokens(result.Cluster, rawConfig[result.Cluster].getUserName(result.Cluster), result.Kubeconfig)
+		if tokens != nil && hasAudiences(tokens.IDToken, group.audiences) {
+			previousTokens = tokens
+			break
+		}
+	}
+
+	kubeLogin, err := getKubeLogin(config)
+	if err != nil {
+		return results, err
+	}
+	pendingClusters := make([]string, 0, len(pending))
+	for _, result := range pending {
+		pendingClusters = append(pendingClusters, result.Cluster)
+	}
+	fmt.Fprintf(os.Stderr, "Logging in to %s for %s\n", group.issuer, strings.Join(pendingClusters, ", "))
+
+	prompt := &loginPrompt{out: os.Stderr}
+	tokens, err := obtainTokens(ctx, opts, group.issuer, config, kubeLogin, previousTokens, prompt)
+	for _, result := range pending {
+		result.LoginURL = prompt.url
+	}
+	if err != nil {
+		return results, err
+	}
+	if len(pending) > 1 {
+		tokens.Shared = sharedTokensName(group.issuer, group.clientID)
+	}
+
+	var failed []string
+	var firstErr error
+	for _, result := range pending {
+		clusterConfig := rawConfig[result.Cluster]
+		if err := applyLogin(masterKubeconfig, result.Cluster, clusterConfig.Aliases[0], clusterConfig, kubeLogin, tokens); err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot log in to %s: %v\n", result.Cluster, err)
+			failed = append(failed, result.Cluster)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		result.LoggedIn = true
+	}
+	if firstErr != nil {
+		return results, errorf(exitCodeOf(firstErr), "cannot write the credentials of %s", strings.Join(failed, ", "))
+	}
+	return results, nil
+}
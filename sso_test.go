@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var ssoConfig = map[string]*configuration{
+	"prod-eu":    {Issuer: "https://dex.ft.com", Aliases: []string{"prod-eu"}, Audience: "prod-eu-apiserver"},
+	"prod-us":    {Issuer: "https://dex.ft.com/", Aliases: []string{"prod-us"}, Scopes: []string{"groups", "offline_access", "federated:id"}},
+	"github":     {Issuer: "https://dex.ft.com", Aliases: []string{"github"}, ClientID: "upp-github"},
+	"pac":        {Issuer: "https://pac-dex.ft.com", Aliases: []string{"pac"}},
+	"no-aliases": {Issuer: "https://dex.ft.com"},
+}
+
+func TestCrossClientScopes(t *testing.T) {
+	assert.Nil(t, crossClientScopes("kubectl-login", nil))
+	assert.Nil(t, crossClientScopes("kubectl-login", []string{"kubectl-login", "kubectl-login"}))
+	assert.Equal(t, []string{
+		"audience:server:client_id:kubectl-login",
+		"audience:server:client_id:a",
+		"audience:server:client_id:b",
+	}, crossClientScopes("kubectl-login", []string{"b", "kubectl-login", "a", "b"}))
+}
+
+func TestSsoGroups(t *testing.T) {
+	groups := ssoGroups(ssoConfig, "")
+	assert.Equal(t, []*ssoGroup{
+		{issuer: "https://dex.ft.com", clientID: "upp-github", clusters: []string{"github"}, audiences: []string{"upp-github"}},
+		{issuer: "https://pac-dex.ft.com", clientID: "kubectl-login", clusters: []string{"pac"}, audiences: []string{"kubectl-login"}},
+		{issuer: "https://dex.ft.com", clientID: "kubectl-login", clusters: []string{"prod-eu", "prod-us"}, audiences: []string{"prod-eu-apiserver", "kubectl-login"}},
+	}, groups, "clusters without an alias are left out and trailing slashes of the issuer ignored")
+
+	groups = ssoGroups(ssoConfig, "https://dex.ft.com/")
+	assert.Len(t, groups, 2)
+	assert.Equal(t, []string{"github"}, groups[0].clusters)
+	assert.Equal(t, []string{"prod-eu", "prod-us"}, groups[1].clusters)
+
+	assert.Empty(t, ssoGroups(ssoConfig, "https://unknown.ft.com"))
+}
+
+func TestSsoGroupLoginConfig(t *testing.T) {
+	group := ssoGroups(ssoConfig, "")[2]
+	config := group.loginConfig(ssoConfig)
+
+	assert.Equal(t, []string{
+		"openid", "profile", "email", "groups", "offline_access", "federated:id",
+		"audience:server:client_id:kubectl-login", "audience:server:client_id:prod-eu-apiserver",
+	}, config.getScopes())
+	assert.Equal(t, "kubectl-login", config.getClientID())
+	assert.Equal(t, "prod-eu-apiserver", ssoConfig["prod-eu"].Audience, "the cluster's configuration is left as is")
+}
+
+func TestHasAudiences(t *testing.T) {
+	claims := validTestClaims()
+	assert.True(t, hasAudiences(signTestToken(t, claims), []string{clientID}))
+	assert.False(t, hasAudiences(signTestToken(t, claims), []string{clientID, "prod-eu-apiserver"}))
+
+	claims["aud"] = []string{clientID, "prod-eu-apiserver"}
+	assert.True(t, hasAudiences(signTestToken(t, claims), []string{clientID, "prod-eu-apiserver"}))
+
+	assert.False(t, hasAudiences("not-a-jwt", []string{clientID}))
+}
+
+func TestWriteLoginResults(t *testing.T) {
+	results := []*loginResult{
+		{Kubeconfig: "kubeconfig_prod-eu", Cluster: "prod-eu", LoggedIn: true, LoginURL: "https://dex.ft.com/auth"},
+		{Kubeconfig: "kubeconfig_prod-us", Cluster: "prod-us"},
+	}
+
+	var out bytes.Buffer
+	assert.NoError(t, writeLoginResults(&out, "", results))
+	assert.Equal(t, "kubeconfig_prod-eu\n", out.String())
+
+	out.Reset()
+	assert.NoError(t, writeLoginResults(&out, outputJSON, results))
+	assert.JSONEq(t, `[
+		{"kubeconfig":"kubeconfig_prod-eu","cluster":"prod-eu","loggedIn":true,"loginUrl":"https://dex.ft.com/auth"},
+		{"kubeconfig":"kubeconfig_prod-us","cluster":"prod-us","loggedIn":false,"loginUrl":""}
+	]`, out.String())
+
+	out.Reset()
+	assert.NoError(t, writeLoginResults(&out, outputEnv, results))
+	assert.Empty(t, out.String())
+}
+
+func TestLoginSingleSignOnTakesNoAlias(t *testing.T) {
+	err := run([]string{"login", "--all", "prod-eu"})
+	assert.Error(t, err)
+	assert.Equal(t, exitUsage, exitCodeOf(err))
+}
+
+func TestLoginGroup(t *testing.T) {
+	home, _ := ioutil.TempDir(os.TempDir(), "home")
+	defer os.RemoveAll(home)
+	originalHome := os.Getenv("HOME")
+	defer os.Setenv("HOME", originalHome)
+	os.Setenv("HOME", home)
+
+	var issuedIdToken string
+	issuer := newTestIssuer(t, func(issuer string) map[string]interface{} {
+		claims := validTestClaims()
+		claims["iss"] = issuer
+		issuedIdToken = signTestToken(t, claims)
+		return map[string]interface{}{"access_token": "access", "token_type": "bearer", "id_token": issuedIdToken, "refresh_token": "new-refresh"}
+	}, nil)
+	defer issuer.Close()
+	apiServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer apiServer.Close()
+
+	caData := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: apiServer.Certificate().Raw})
+	master := &kubeconfig{}
+	rawConfig := map[string]*configuration{}
+	for _, cluster := range []string{"delivery", "publishing", "pac"} {
+		master.Clusters = append(master.Clusters, namedCluster{Name: cluster, Cluster: kubeCluster{
+			Server:                   apiServer.URL,
+			CertificateAuthorityData: base64.StdEncoding.EncodeToString(caData),
+		}})
+		rawConfig[cluster] = &configuration{Issuer: issuer.URL, Aliases: []string{cluster}, LoginSecret: "secret"}
+	}
+	masterKubeconfig := filepath.Join(home, "kubeconfig")
+	assert.NoError(t, master.save(masterKubeconfig))
+
+	// pac already has a session of its own, delivery has one to refresh
+	validToken := signTestToken(t, validTestClaims())
+	pacKubeconfig, _ := switchConfig(masterKubeconfig, "pac", false)
+	setIdTokenCreds(clientID, validToken, pacKubeconfig)
+	switchContext("pac", "pac", clientID, "", pacKubeconfig)
+	saveCachedTokens("delivery", &cachedTokens{IDToken: validToken, RefreshToken: "old-refresh"})
+
+	groups := ssoGroups(rawConfig, "")
+	assert.Len(t, groups, 1)
+	results, err := loginGroup(context.Background(), &options{}, rawConfig, masterKubeconfig, groups[0])
+	assert.NoError(t, err)
+	assert.Len(t, results, 3)
+	for _, result := range results {
+		assert.True(t, result.LoggedIn, result.Cluster)
+	}
+
+	for _, cluster := range []string{"delivery", "publishing"} {
+		k, err := loadKubeconfig(getClusterConfig(masterKubeconfig, cluster))
+		if assert.NoError(t, err, cluster) {
+			assert.Equal(t, issuedIdToken, k.user(clientID).Token, cluster)
+			assert.Nil(t, k.user(clientID).AuthProvider, "kubectl mustn't refresh the shared token of "+cluster)
+		}
+		tokens, err := loadCachedTokens(cluster)
+		if assert.NoError(t, err, cluster) {
+			assert.Equal(t, "new-refresh", tokens.RefreshToken, cluster)
+			assert.Equal(t, sharedTokensName(issuer.URL, clientID), tokens.Shared, cluster)
+		}
+	}
+	k, _ := loadKubeconfig(pacKubeconfig)
+	assert.Equal(t, validToken, k.user(clientID).Token, "the valid session of pac is kept")
+	_, err = loadCachedTokens("pac")
+	assert.Error(t, err, "pac wasn't logged in to")
+}
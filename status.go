@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/coreos/go-oidc"
+)
+
+const (
+	statusCommandName = "status"
+	whoamiCommandName = "whoami"
+	outputText        = "text"
+	outputJSON        = "json"
+)
+
+// sessionStatus describes the identity held by a per-cluster kubeconfig.
+type sessionStatus struct {
+	Cluster           string    `json:"cluster"`
+	Kubeconfig        string    `json:"kubeconfig"`
+	Subject           string    `json:"subject"`
+	Email             string    `json:"email,omitempty"`
+	Groups            []string  `json:"groups"`
+	Issuer            string    `json:"issuer"`
+	Audience          []string  `json:"audience"`
+	Expiry            time.Time `json:"expiry"`
+	Expired           bool      `json:"expired"`
+	TimeLeft          string    `json:"timeLeft"`
+	Verified          bool      `json:"verified"`
+	VerificationError string    `json:"verificationError,omitempty"`
+	// Session is what the API server makes of the token, see checkSession.
+	Session      sessionState `json:"session"`
+	SessionError string       `json:"sessionError,omitempty"`
+}
+
+type idTokenClaims struct {
+	Issuer   string   `json:"iss"`
+	Subject  string   `json:"sub"`
+	Audience audience `json:"aud"`
+	Expiry   int64    `json:"exp"`
+	Email    string   `json:"email"`
+	Groups   []string `json:"groups"`
+}
+
+// audience accepts both forms of the aud claim, a single string or an array.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(b, &multiple); err != nil {
+		return err
+	}
+	*a = multiple
+	return nil
+}
+
+// statusCommand implements "kubectl-login status [alias]". Without an alias it describes the cluster
+// of the per-cluster kubeconfig KUBECONFIG currently points at.
+func statusCommand(args []string) error {
+	flags := flag.NewFlagSet(statusCommandName, flag.ExitOnError)
+	output := flags.String("output", outputText, "output format, text or json")
+	args = parseInterleaved(flags, args)
+
+	rawConfig, err := getRawConfig()
+	if err != nil {
+		return err
+	}
+	currentKubeconfig := os.Getenv("KUBECONFIG")
+	var config *configuration
+	var cluster string
+	if len(args) > 0 {
+		if config, cluster, err = getConfigByAlias(args[0], rawConfig); err != nil {
+			return err
+		}
+	} else {
+		if isMasterConfig(currentKubeconfig) || currentKubeconfig == "" {
+			return errorf(exitUsage, "KUBECONFIG is not a per-cluster kubeconfig, try '%s'", "kubectl-login status <ALIAS>")
+		}
+		cluster = strings.TrimPrefix(currentKubeconfig, getMasterConfig(currentKubeconfig)+"_")
+		if config = rawConfig[cluster]; config == nil {
+			return errorf(exitAliasNotFound, "cluster %s of KUBECONFIG %s is not in your config file", cluster, currentKubeconfig)
+		}
+	}
+
+	clusterKubeconfig := getClusterConfig(getMasterConfig(currentKubeconfig), cluster)
+	status, err := getSessionStatus(context.Background(), config, cluster, clusterKubeconfig)
+	if err != nil {
+		return errorf(exitAuth, "%w", err)
+	}
+
+	switch *output {
+	case outputJSON:
+		err = json.NewEncoder(os.Stdout).Encode(status)
+	case outputText:
+		err = printSessionStatus(os.Stdout, status)
+	default:
+		return errorf(exitUsage, "unknown output format %s", *output)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot write status: %w", err)
+	}
+	return nil
+}
+
+// getSessionStatus decodes the id token stored for the cluster. The signature is checked against the
+// issuer's cached signing keys, which works offline once they have been fetched.
+func getSessionStatus(ctx context.Context, config *configuration, cluster, clusterKubeconfig string) (*sessionStatus, error) {
+	rawIdToken, err := storedIdToken(cluster, config.getUserName(cluster), clusterKubeconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	var claims idTokenClaims
+	if err := decodeIdTokenClaims(rawIdToken, &claims); err != nil {
+		return nil, err
+	}
+	expiry := time.Unix(claims.Expiry, 0)
+	status := &sessionStatus{
+		Cluster:    cluster,
+		Kubeconfig: clusterKubeconfig,
+		Subject:    claims.Subject,
+		Email:      claims.Email,
+		Groups:     claims.Groups,
+		Issuer:     claims.Issuer,
+		Audience:   claims.Audience,
+		Expiry:     expiry,
+		Expired:    time.Now().After(expiry),
+		TimeLeft:   time.Until(expiry).Round(time.Second).String(),
+	}
+
+	// expiry is reported rather than checked, so expired sessions can still be described
+	verifier := oidc.NewVerifier(config.Issuer, newCachedKeySet(config.Issuer), &oidc.Config{ClientID: config.getClientID(), SkipExpiryCheck: true})
+	if err := verifyIdToken(ctx, verifier, rawIdToken, ""); err != nil {
+		status.VerificationError = err.Error()
+	} else {
+		status.Verified = true
+	}
+
+	status.Session, err = checkSession(ctx, clusterKubeconfig)
+	if err != nil {
+		status.SessionError = err.Error()
+	}
+	return status, nil
+}
+
+// storedIdToken finds the id token of the cluster's user, whichever way it was written to the kubeconfig.
+func storedIdToken(cluster, userName, clusterKubeconfig string) (string, error) {
+	k, err := loadKubeconfig(clusterKubeconfig)
+	if err != nil {
+		return "", fmt.Errorf("not logged in to %s: %v", cluster, err)
+	}
+	user := k.user(userName)
+	switch {
+	case user.Token != "":
+		return user.Token, nil
+	case user.AuthProvider != nil && user.AuthProvider.Config["id-token"] != "":
+		return user.AuthProvider.Config["id-token"], nil
+	case user.Exec != nil:
+		tokens, err := loadCachedTokens(cluster)
+		if err != nil {
+			return "", fmt.Errorf("no cached tokens for %s: %v", cluster, err)
+		}
+		return tokens.IDToken, nil
+	}
+	return "", errors.New("no kubectl-login credentials in " + clusterKubeconfig)
+}
+
+func printSessionStatus(w io.Writer, status *sessionStatus) error {
+	expiry := status.Expiry.Local().Format(time.RFC1123)
+	if status.Expired {
+		expiry += " (expired)"
+	} else {
+		expiry += " (" + status.TimeLeft + " left)"
+	}
+	session := string(status.Session)
+	if status.SessionError != "" {
+		session += ", " + status.SessionError
+	}
+	verified := "yes"
+	if !status.Verified {
+		verified = "no, " + status.VerificationError
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Cluster:\t%s\n", status.Cluster)
+	fmt.Fprintf(tw, "Kubeconfig:\t%s\n", status.Kubeconfig)
+	fmt.Fprintf(tw, "Subject:\t%s\n", status.Subject)
+	fmt.Fprintf(tw, "Email:\t%s\n", status.Email)
+	fmt.Fprintf(tw, "Groups:\t%s\n", strings.Join(status.Groups, ", "))
+	fmt.Fprintf(tw, "Issuer:\t%s\n", status.Issuer)
+	fmt.Fprintf(tw, "Audience:\t%s\n", strings.Join(status.Audience, ", "))
+	fmt.Fprintf(tw, "Expires:\t%s\n", expiry)
+	fmt.Fprintf(tw, "Verified:\t%s\n", verified)
+	fmt.Fprintf(tw, "Session:\t%s\n", session)
+	return tw.Flush()
+}
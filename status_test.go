@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAudienceUnmarshal(t *testing.T) {
+	var testCases = []struct {
+		input    string
+		expected audience
+	}{
+		{input: `"kubectl-login"`, expected: audience{"kubectl-login"}},
+		{input: `["kubectl-login","other"]`, expected: audience{"kubectl-login", "other"}},
+	}
+	for _, tc := range testCases {
+		var actual audience
+		assert.NoError(t, json.Unmarshal([]byte(tc.input), &actual))
+		assert.Equal(t, tc.expected, actual)
+	}
+}
+
+func TestGetSessionStatus(t *testing.T) {
+	home, _ := ioutil.TempDir(os.TempDir(), "home")
+	defer os.RemoveAll(home)
+	originalHome := os.Getenv("HOME")
+	defer os.Setenv("HOME", originalHome)
+	os.Setenv("HOME", home)
+
+	server := newTestIssuer(t, nil, nil)
+	claims := validTestClaims()
+	claims["iss"] = server.URL
+	claims["groups"] = []string{"content", "admins"}
+	claims["exp"] = time.Now().Add(-time.Minute).Unix()
+	rawIdToken := signTestToken(t, claims)
+
+	path := writeTempKubeconfig(t, testKubeconfig)
+	defer os.Remove(path)
+	setOIDCAuth(clientID, clientID, "secret", rawIdToken, "refresh", server.URL, path)
+
+	config := &configuration{Issuer: server.URL}
+	status, err := getSessionStatus(context.Background(), config, "config1", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "config1", status.Cluster)
+	assert.Equal(t, path, status.Kubeconfig)
+	assert.Equal(t, claims["sub"], status.Subject)
+	assert.Equal(t, "jane.doe@ft.com", status.Email)
+	assert.Equal(t, []string{"content", "admins"}, status.Groups)
+	assert.Equal(t, server.URL, status.Issuer)
+	assert.Equal(t, []string{clientID}, status.Audience)
+	assert.True(t, status.Expired)
+	assert.True(t, status.Verified, status.VerificationError)
+
+	server.Close()
+	status, err = getSessionStatus(context.Background(), config, "config1", path)
+	assert.NoError(t, err)
+	assert.True(t, status.Verified, "the signature should be verified offline with the cached keys")
+}
+
+func TestGetSessionStatusUnverified(t *testing.T) {
+	home, _ := ioutil.TempDir(os.TempDir(), "home")
+	defer os.RemoveAll(home)
+	originalHome := os.Getenv("HOME")
+	defer os.Setenv("HOME", originalHome)
+	os.Setenv("HOME", home)
+
+	path := writeTempKubeconfig(t, testKubeconfig)
+	defer os.Remove(path)
+	setIdTokenCreds(clientID, signTestToken(t, validTestClaims()), path)
+
+	config := &configuration{Issuer: "http://127.0.0.1:1"}
+	status, err := getSessionStatus(context.Background(), config, "config1", path)
+	assert.NoError(t, err)
+	assert.False(t, status.Verified)
+	assert.NotEmpty(t, status.VerificationError)
+	assert.False(t, status.Expired)
+}
+
+func TestStoredIdTokenNotLoggedIn(t *testing.T) {
+	_, err := storedIdToken("config1", clientID, "does-not-exist")
+	assert.Error(t, err)
+
+	path := writeTempKubeconfig(t, kubeconfigWithUnknownFields)
+	defer os.Remove(path)
+	_, err = storedIdToken("config1", clientID, path)
+	assert.Error(t, err)
+}
+
+func TestPrintSessionStatus(t *testing.T) {
+	status := &sessionStatus{
+		Cluster:  "config1",
+		Subject:  "subject",
+		Email:    "jane.doe@ft.com",
+		Groups:   []string{"content", "admins"},
+		Issuer:   testIssuer,
+		Audience: []string{clientID},
+		Expiry:   time.Now().Add(time.Hour),
+		TimeLeft: "1h0m0s",
+		Verified: true,
+	}
+	var out bytes.Buffer
+	assert.NoError(t, printSessionStatus(&out, status))
+	assert.Contains(t, out.String(), "jane.doe@ft.com")
+	assert.Contains(t, out.String(), "content, admins")
+	assert.Contains(t, out.String(), "1h0m0s left")
+	assert.Contains(t, out.String(), "Verified:    yes")
+}
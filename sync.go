@@ -0,0 +1,116 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const syncCommandName = "sync"
+
+// syncCluster re-applies the master kubeconfig's entry of the cluster, e.g. a rotated certificate authority or
+// a new server URL, to its per-cluster kubeconfig. The users and contexts are kept, so the session survives.
+// It reports whether the per-cluster kubeconfig had drifted. Clusters which were never logged in to are left alone.
+func syncCluster(masterKubeconfig, cluster string, minify bool) (bool, error) {
+	clusterKubeconfig := getClusterConfig(masterKubeconfig, cluster)
+	if _, err := os.Stat(clusterKubeconfig); os.IsNotExist(err) {
+		return false, nil
+	}
+
+	master, err := loadKubeconfig(masterKubeconfig)
+	if err != nil {
+		return false, errorf(exitKubeconfig, "could not read kubeconfig %s: %w", masterKubeconfig, err)
+	}
+	var entry *namedCluster
+	if minify {
+		// the minified copies have the certificate authority inlined, so they are compared with a minified master
+		minified, err := master.minify(cluster, filepath.Dir(masterKubeconfig))
+		if err != nil {
+			return false, errorf(exitKubeconfig, "cannot sync %s with %s: %w", clusterKubeconfig, masterKubeconfig, err)
+		}
+		entry = &minified.Clusters[0]
+	} else {
+		for i := range master.Clusters {
+			if master.Clusters[i].Name == cluster {
+				entry = &master.Clusters[i]
+			}
+		}
+		if entry == nil {
+			return false, errorf(exitKubeconfig, "cannot sync %s with %s: no cluster %s", clusterKubeconfig, masterKubeconfig, cluster)
+		}
+	}
+
+	unlock, err := lockFile(clusterKubeconfig)
+	if err != nil {
+		return false, errorf(exitKubeconfig, "%w", err)
+	}
+	defer unlock()
+	k, err := loadKubeconfig(clusterKubeconfig)
+	if err != nil {
+		return false, errorf(exitKubeconfig, "could not read kubeconfig %s: %w", clusterKubeconfig, err)
+	}
+	if !k.setCluster(*entry) {
+		return false, nil
+	}
+	if err := k.save(clusterKubeconfig); err != nil {
+		return false, errorf(exitKubeconfig, "could not write kubeconfig %s: %w", clusterKubeconfig, err)
+	}
+	return true, nil
+}
+
+// syncBeforeLogin keeps the per-cluster kubeconfig of a session in line with the master kubeconfig. A failure
+// only means the session is checked as it is, so it is reported as a warning.
+func syncBeforeLogin(masterKubeconfig, cluster string, config *configuration) {
+	changed, err := syncCluster(masterKubeconfig, cluster, config.MinifyKubeconfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+	} else if changed {
+		fmt.Fprintf(os.Stderr, "Updated cluster %s from %s\n", cluster, masterKubeconfig)
+	}
+}
+
+// syncCommand implements "kubectl-login sync", syncing the per-cluster kubeconfig of every cluster of the config file.
+func syncCommand(args []string) error {
+	flags := flag.NewFlagSet(syncCommandName, flag.ExitOnError)
+	flags.Parse(args)
+
+	rawConfig, err := getRawConfig()
+	if err != nil {
+		return err
+	}
+	masterKubeconfig, err := requireMasterConfig()
+	if err != nil {
+		return err
+	}
+	return syncClusters(os.Stdout, rawConfig, masterKubeconfig)
+}
+
+// syncClusters syncs every cluster, even after one failed, and prints the ones that were updated.
+func syncClusters(w io.Writer, rawConfig map[string]*configuration, masterKubeconfig string) error {
+	clusters := make([]string, 0, len(rawConfig))
+	for cluster := range rawConfig {
+		clusters = append(clusters, cluster)
+	}
+	sort.Strings(clusters)
+
+	var firstErr error
+	for _, cluster := range clusters {
+		changed, err := syncCluster(masterKubeconfig, cluster, rawConfig[cluster].MinifyKubeconfig)
+		switch {
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "Cannot sync %s: %v\n", cluster, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		case changed:
+			fmt.Fprintf(w, "%s: updated from %s\n", cluster, masterKubeconfig)
+		}
+	}
+	if firstErr != nil {
+		return errorf(exitCodeOf(firstErr), "cannot sync every cluster")
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const syncMasterKubeconfig = `
+apiVersion: v1
+kind: Config
+clusters:
+- cluster:
+    server: https://delivery.ft.com
+    certificate-authority: ca.pem
+    proxy-url: http://proxy.ft.com:3128
+  name: delivery
+- cluster:
+    server: https://publishing.ft.com
+  name: publishing
+`
+
+func writeSyncMaster(t *testing.T) (string, func()) {
+	dir, _ := ioutil.TempDir(os.TempDir(), "sync")
+	ioutil.WriteFile(filepath.Join(dir, "ca.pem"), []byte("ca-data"), 0600)
+	masterKubeconfig := filepath.Join(dir, "kubeconfig")
+	ioutil.WriteFile(masterKubeconfig, []byte(syncMasterKubeconfig), 0600)
+	return masterKubeconfig, func() { os.RemoveAll(dir) }
+}
+
+func TestSyncCluster(t *testing.T) {
+	masterKubeconfig, cleanup := writeSyncMaster(t)
+	defer cleanup()
+	clusterKubeconfig, err := switchConfig(masterKubeconfig, "delivery", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setOIDCAuth(clientID, clientID, "secret", "id-token", "refresh-token", "https://dex.ft.com", clusterKubeconfig)
+	switchContext("delivery", "delivery", clientID, "default", clusterKubeconfig)
+
+	changed, err := syncCluster(masterKubeconfig, "delivery", false)
+	assert.NoError(t, err)
+	assert.False(t, changed, "nothing has drifted")
+
+	// content-k8s-auth-setup moved the API server
+	master, _ := loadKubeconfig(masterKubeconfig)
+	master.Clusters[0].Cluster.Server = "https://new-delivery.ft.com"
+	master.save(masterKubeconfig)
+
+	changed, err = syncCluster(masterKubeconfig, "delivery", false)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+
+	k, _ := loadKubeconfig(clusterKubeconfig)
+	assert.Equal(t, master.Clusters, k.Clusters)
+	assert.Equal(t, "refresh-token", k.user(clientID).AuthProvider.Config["refresh-token"], "the session is kept")
+	assert.Equal(t, "delivery", k.CurrentContext)
+
+	changed, err = syncCluster(masterKubeconfig, "delivery", false)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+}
+
+func TestSyncClusterMinified(t *testing.T) {
+	masterKubeconfig, cleanup := writeSyncMaster(t)
+	defer cleanup()
+	clusterKubeconfig, err := switchConfig(masterKubeconfig, "delivery", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setIdTokenCreds(clientID, "token", clusterKubeconfig)
+
+	changed, err := syncCluster(masterKubeconfig, "delivery", true)
+	assert.NoError(t, err)
+	assert.False(t, changed, "the inlined certificate authority is the same")
+
+	// the certificate authority was rotated
+	ioutil.WriteFile(filepath.Join(filepath.Dir(masterKubeconfig), "ca.pem"), []byte("rotated-ca-data"), 0600)
+	changed, err = syncCluster(masterKubeconfig, "delivery", true)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+
+	k, _ := loadKubeconfig(clusterKubeconfig)
+	assert.Len(t, k.Clusters, 1)
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("rotated-ca-data")), k.Clusters[0].Cluster.CertificateAuthorityData)
+	assert.Equal(t, "token", k.user(clientID).Token)
+}
+
+func TestSyncClusterNotLoggedIn(t *testing.T) {
+	masterKubeconfig, cleanup := writeSyncMaster(t)
+	defer cleanup()
+
+	changed, err := syncCluster(masterKubeconfig, "delivery", false)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+	_, err = os.Stat(getClusterConfig(masterKubeconfig, "delivery"))
+	assert.True(t, os.IsNotExist(err), "no per-cluster kubeconfig is created")
+}
+
+func TestSyncClusterRemovedFromMaster(t *testing.T) {
+	masterKubeconfig, cleanup := writeSyncMaster(t)
+	defer cleanup()
+	ioutil.WriteFile(getClusterConfig(masterKubeconfig, "staging"), []byte(syncMasterKubeconfig), 0600)
+
+	_, err := syncCluster(masterKubeconfig, "staging", false)
+	assert.Error(t, err)
+	assert.Equal(t, exitKubeconfig, exitCodeOf(err))
+}
+
+func TestSyncClusters(t *testing.T) {
+	masterKubeconfig, cleanup := writeSyncMaster(t)
+	defer cleanup()
+	for _, cluster := range []string{"delivery", "publishing"} {
+		if _, err := switchConfig(masterKubeconfig, cluster, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	master, _ := loadKubeconfig(masterKubeconfig)
+	master.Clusters[1].Cluster.Server = "https://new-publishing.ft.com"
+	master.save(masterKubeconfig)
+
+	rawConfig := map[string]*configuration{"delivery": {}, "publishing": {}, "pac": {}}
+	var out bytes.Buffer
+	assert.NoError(t, syncClusters(&out, rawConfig, masterKubeconfig))
+	assert.Equal(t, "publishing: updated from "+masterKubeconfig+"\n", out.String())
+
+	ioutil.WriteFile(getClusterConfig(masterKubeconfig, "pac"), []byte(syncMasterKubeconfig), 0600)
+	out.Reset()
+	err := syncClusters(&out, rawConfig, masterKubeconfig)
+	assert.Error(t, err, "pac is no longer in the master kubeconfig")
+	assert.False(t, strings.Contains(out.String(), "pac"))
+}
+
+func TestSyncCommandWithoutKubeconfig(t *testing.T) {
+	home, _ := ioutil.TempDir(os.TempDir(), "home")
+	defer os.RemoveAll(home)
+	marshaledConfig, _ := json.Marshal(validConfig)
+	ioutil.WriteFile(filepath.Join(home, configFile), marshaledConfig, 0644)
+
+	originalHome, originalKubeconfig := os.Getenv("HOME"), os.Getenv("KUBECONFIG")
+	originalDir, _ := os.Getwd()
+	defer func() {
+		os.Setenv("HOME", originalHome)
+		os.Setenv("KUBECONFIG", originalKubeconfig)
+		os.Chdir(originalDir)
+	}()
+	os.Setenv("HOME", home)
+	os.Unsetenv("KUBECONFIG")
+	os.Chdir(home)
+	ioutil.WriteFile("_config1", []byte(syncMasterKubeconfig), 0600)
+
+	err := syncCommand(nil)
+	assert.Error(t, err)
+	assert.Equal(t, exitKubeconfig, exitCodeOf(err))
+	assert.NoFileExists(t, filepath.Join(home, "_config1.lock"))
+}
@@ -0,0 +1,18 @@
+//go:build !darwin && !linux
+
+package main
+
+import "errors"
+
+// The picker is only supported on linux and macos, elsewhere an alias has to be given.
+func isTerminal(fd int) bool {
+	return false
+}
+
+func makeRaw(fd int) (func(), error) {
+	return nil, errors.New("raw mode is not supported")
+}
+
+func terminalWidth(fd int) int {
+	return 0
+}
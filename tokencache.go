@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/coreos/go-oidc"
+	"golang.org/x/oauth2"
+)
+
+// tokenCacheDir follows kubectl's convention of keeping client side caches under ~/.kube/cache.
+var tokenCacheDir = filepath.Join(".kube", "cache", "kubectl-login")
+
+type cachedTokens struct {
+	IDToken      string `json:"idToken"`
+	RefreshToken string `json:"refreshToken,omitempty"`
+	// Shared names the entry holding the tokens of the clusters logged in to together, see sharedTokensName.
+	// The entry of each cluster then only points at it.
+	Shared string `json:"shared,omitempty"`
+}
+
+func tokenCachePath(cluster string) string {
+	return filepath.Join(os.Getenv("HOME"), tokenCacheDir, cluster+".json")
+}
+
+func sharedTokensPath(name string) string {
+	return filepath.Join(os.Getenv("HOME"), tokenCacheDir, "shared", name+".json")
+}
+
+// sharedTokensName names the tokens shared by the clusters of an issuer and client. Issuers like Dex rotate the
+// refresh token on every refresh, so the clusters must redeem it from a single place: a copy in the cache entry
+// of each cluster would be spent by the first refresh.
+func sharedTokensName(issuer, clientID string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSuffix(issuer, "/") + " " + clientID))
+	return hex.EncodeToString(sum[:8])
+}
+
+func readCachedTokens(path string) (*cachedTokens, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	tokens := &cachedTokens{}
+	if err := json.Unmarshal(data, tokens); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func loadCachedTokens(cluster string) (*cachedTokens, error) {
+	tokens, err := readCachedTokens(tokenCachePath(cluster))
+	if err != nil || tokens.Shared == "" {
+		return tokens, err
+	}
+	shared, err := readCachedTokens(sharedTokensPath(tokens.Shared))
+	if err != nil {
+		return nil, err
+	}
+	shared.Shared = tokens.Shared
+	return shared, nil
+}
+
+// saveCachedTokens stores the tokens readable by the current user only, as they grant access to the cluster.
+// Shared tokens are stored once for all their clusters.
+func saveCachedTokens(cluster string, tokens *cachedTokens) error {
+	if tokens.Shared != "" {
+		shared := *tokens
+		shared.Shared = ""
+		if err := writeCachedTokens(sharedTokensPath(tokens.Shared), &shared); err != nil {
+			return err
+		}
+		tokens = &cachedTokens{Shared: tokens.Shared}
+	}
+	return writeCachedTokens(tokenCachePath(cluster), tokens)
+}
+
+func writeCachedTokens(path string, tokens *cachedTokens) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(tokens)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// sharedTokensInUse reports whether the entry of any cluster other than cluster points at the shared tokens.
+func sharedTokensInUse(name, cluster string) bool {
+	paths, _ := filepath.Glob(filepath.Join(os.Getenv("HOME"), tokenCacheDir, "*.json"))
+	for _, path := range paths {
+		if path == tokenCachePath(cluster) {
+			continue
+		}
+		if tokens, err := readCachedTokens(path); err == nil && tokens.Shared == name {
+			return true
+		}
+	}
+	return false
+}
+
+// loadPreviousTokens returns the tokens of the last login to the cluster. The oidc auth-provider entry in its
+// per-cluster kubeconfig comes first: kubectl refreshes the tokens there without updating the cache, so with an
+// issuer that rotates refresh tokens the cached one is already spent. Exec credential logins only keep their tokens
+// in the cache. It returns nil if there are none.
+func loadPreviousTokens(cluster, userName, clusterKubeconfig string) *cachedTokens {
+	if k, err := loadKubeconfig(clusterKubeconfig); err == nil {
+		authProvider := k.user(userName).AuthProvider
+		if authProvider != nil && authProvider.Config["refresh-token"] != "" {
+			return &cachedTokens{IDToken: authProvider.Config["id-token"], RefreshToken: authProvider.Config["refresh-token"]}
+		}
+	}
+
+	if tokens, err := loadCachedTokens(cluster); err == nil {
+		return tokens
+	}
+	return nil
+}
+
+// refreshCachedTokens redeems the cached refresh token for a new id token and verifies it with the issuer's keys.
+// Issuers that rotate refresh tokens return a new one, otherwise the cached one is kept. Shared tokens stay shared,
+// so every cluster gets the rotated one. The client secret is
+// resolved by the caller, as reading it may run a command or prompt to unlock the keyring.
+func refreshCachedTokens(ctx context.Context, config *configuration, clientSecret string, tokens *cachedTokens) (*cachedTokens, error) {
+	if tokens.RefreshToken == "" {
+		return nil, errorf(exitAuth, "no refresh token cached")
+	}
+
+	provider, err := oidc.NewProvider(ctx, config.Issuer)
+	if err != nil {
+		return nil, errorf(exitNetwork, "cannot initialize OIDC provider for issuer %s: %w", config.Issuer, err)
+	}
+	oauth2Config := newOAuth2Config(config, provider.Endpoint(), clientSecret)
+
+	token, err := oauth2Config.TokenSource(ctx, &oauth2.Token{RefreshToken: tokens.RefreshToken}).Token()
+	if err != nil {
+		return nil, errorf(exitAuth, "%w", err)
+	}
+	rawIdToken, refreshToken, err := tokensFromResponse(token)
+	if err != nil {
+		return nil, errorf(exitAuth, "%w", err)
+	}
+	if err := verifyIdToken(ctx, provider.Verifier(&oidc.Config{ClientID: config.getClientID()}), rawIdToken, ""); err != nil {
+		return nil, errorf(exitAuth, "refreshed token is invalid: %w", err)
+	}
+
+	if refreshToken == "" {
+		refreshToken = tokens.RefreshToken
+	}
+	return &cachedTokens{IDToken: rawIdToken, RefreshToken: refreshToken, Shared: tokens.Shared}, nil
+}
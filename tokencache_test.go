@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/square/go-jose.v2"
+)
+
+// testIssuerHandler serves one path of the test issuer, which is at issuer.
+type testIssuerHandler func(w http.ResponseWriter, r *http.Request, issuer string)
+
+// newTestIssuer serves discovery, the signing keys of signTestToken and a token endpoint answering with tokenResponse.
+// handlers replace what is served on their path, e.g. to answer another grant or advertise more endpoints.
+func newTestIssuer(t *testing.T, tokenResponse func(issuer string) map[string]interface{}, handlers map[string]testIssuerHandler) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if handler, ok := handlers[r.URL.Path]; ok {
+			handler(w, r, server.URL)
+			return
+		}
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(testDiscovery(server.URL))
+		case "/keys":
+			json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+				{Key: &testSigningKey.PublicKey, Algorithm: string(jose.RS256), Use: "sig"},
+			}})
+		case "/token":
+			r.ParseForm()
+			if r.Form.Get("grant_type") != "refresh_token" || r.Form.Get("refresh_token") != "old-refresh" {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error":"invalid_grant"}`))
+				return
+			}
+			json.NewEncoder(w).Encode(tokenResponse(server.URL))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	return server
+}
+
+// testDiscovery is the discovery document of the test issuer, handlers add the endpoints they serve to it.
+func testDiscovery(issuer string) map[string]string {
+	return map[string]string{
+		"issuer":                 issuer,
+		"authorization_endpoint": issuer + "/auth",
+		"token_endpoint":         issuer + "/token",
+		"jwks_uri":               issuer + "/keys",
+	}
+}
+
+func TestSaveAndLoadCachedTokens(t *testing.T) {
+	home, _ := ioutil.TempDir(os.TempDir(), "home")
+	defer os.RemoveAll(home)
+	originalHome := os.Getenv("HOME")
+	defer os.Setenv("HOME", originalHome)
+	os.Setenv("HOME", home)
+
+	_, err := loadCachedTokens("config1")
+	assert.Error(t, err)
+
+	expected := &cachedTokens{IDToken: "id-token", RefreshToken: "refresh-token"}
+	assert.NoError(t, saveCachedTokens("config1", expected))
+
+	info, err := os.Stat(tokenCachePath("config1"))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	actual, err := loadCachedTokens("config1")
+	assert.NoError(t, err)
+	assert.Equal(t, expected, actual)
+}
+
+func TestRefreshCachedTokens(t *testing.T) {
+	var testCases = []struct {
+		description          string
+		cached               *cachedTokens
+		returnRefreshToken   string
+		expectError          bool
+		expectedRefreshToken string
+	}{
+		{
+			description:          "issuer rotates the refresh token",
+			cached:               &cachedTokens{IDToken: "expired", RefreshToken: "old-refresh"},
+			returnRefreshToken:   "new-refresh",
+			expectedRefreshToken: "new-refresh",
+		},
+		{
+			description:          "issuer keeps the refresh token",
+			cached:               &cachedTokens{IDToken: "expired", RefreshToken: "old-refresh"},
+			returnRefreshToken:   "",
+			expectedRefreshToken: "old-refresh",
+		},
+		{
+			description: "refresh token rejected",
+			cached:      &cachedTokens{IDToken: "expired", RefreshToken: "revoked-refresh"},
+			expectError: true,
+		},
+		{
+			description: "no refresh token",
+			cached:      &cachedTokens{IDToken: "expired"},
+			expectError: true,
+		},
+	}
+	for _, tc := range testCases {
+		var issuedIdToken string
+		server := newTestIssuer(t, func(issuer string) map[string]interface{} {
+			claims := validTestClaims()
+			claims["iss"] = issuer
+			issuedIdToken = signTestToken(t, claims)
+			response := map[string]interface{}{"access_token": "access", "token_type": "bearer", "id_token": issuedIdToken}
+			if tc.returnRefreshToken != "" {
+				response["refresh_token"] = tc.returnRefreshToken
+			}
+			return response
+		}, nil)
+
+		// the secret is passed in, the config has no source to read it from again
+		config := &configuration{Issuer: server.URL}
+		refreshed, err := refreshCachedTokens(context.Background(), config, "secret", tc.cached)
+		if tc.expectError {
+			assert.Error(t, err, "Scenario: "+tc.description)
+		} else if assert.NoError(t, err, "Scenario: "+tc.description) {
+			assert.Equal(t, issuedIdToken, refreshed.IDToken, "Scenario: "+tc.description)
+			assert.Equal(t, tc.expectedRefreshToken, refreshed.RefreshToken, "Scenario: "+tc.description)
+		}
+		server.Close()
+	}
+}
+
+func TestLoadPreviousTokens(t *testing.T) {
+	home, _ := ioutil.TempDir(os.TempDir(), "home")
+	defer os.RemoveAll(home)
+	originalHome := os.Getenv("HOME")
+	defer os.Setenv("HOME", originalHome)
+	os.Setenv("HOME", home)
+
+	idTokenKubeconfig := writeTempKubeconfig(t, testKubeconfig)
+	defer os.Remove(idTokenKubeconfig)
+	assert.Nil(t, loadPreviousTokens("config1", clientID, idTokenKubeconfig), "a token without refresh token cannot be refreshed")
+
+	oidcKubeconfig := writeTempKubeconfig(t, testKubeconfig)
+	defer os.Remove(oidcKubeconfig)
+	setOIDCAuth(clientID, clientID, "secret", "kubeconfig-id-token", "kubeconfig-refresh", testIssuer, oidcKubeconfig)
+	assert.Equal(t, &cachedTokens{IDToken: "kubeconfig-id-token", RefreshToken: "kubeconfig-refresh"},
+		loadPreviousTokens("config1", clientID, oidcKubeconfig))
+
+	// kubectl's oidc auth-provider saved a rotated refresh token to the kubeconfig, the cached one is spent
+	saveCachedTokens("config1", &cachedTokens{IDToken: "cached-id-token", RefreshToken: "cached-refresh"})
+	assert.Equal(t, &cachedTokens{IDToken: "kubeconfig-id-token", RefreshToken: "kubeconfig-refresh"},
+		loadPreviousTokens("config1", clientID, oidcKubeconfig), "the kubeconfig takes precedence over the cache")
+
+	execKubeconfig := writeTempKubeconfig(t, testKubeconfig)
+	defer os.Remove(execKubeconfig)
+	setExecCredential(clientID, "alias1", execKubeconfig)
+	assert.Equal(t, &cachedTokens{IDToken: "cached-id-token", RefreshToken: "cached-refresh"},
+		loadPreviousTokens("config1", clientID, execKubeconfig), "exec credential logins only keep their tokens in the cache")
+
+	assert.Nil(t, loadPreviousTokens("config2", clientID, "does-not-exist"))
+}
+
+func TestObtainTokensReadsTheSecretOnce(t *testing.T) {
+	server := newTestIssuer(t, func(issuer string) map[string]interface{} {
+		claims := validTestClaims()
+		claims["iss"] = issuer
+		return map[string]interface{}{"access_token": "access", "token_type": "bearer", "id_token": signTestToken(t, claims)}
+	}, nil)
+	defer server.Close()
+
+	var commands []string
+	defer func(original func(string, ...string) *exec.Cmd) { startCommand = original }(startCommand)
+	startCommand = func(name string, args ...string) *exec.Cmd {
+		commands = append(commands, name)
+		return exec.Command("echo", "secret")
+	}
+
+	// the refresh reuses the secret the login already read, rather than running pass show a second time
+	config := &configuration{Issuer: server.URL, LoginSecretCommand: "pass show dex/kubectl-login"}
+	kubeLogin, err := getKubeLogin(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousTokens := &cachedTokens{IDToken: "expired", RefreshToken: "old-refresh"}
+	_, err = obtainTokens(context.Background(), &options{}, "config1", config, kubeLogin, previousTokens, &loginPrompt{out: ioutil.Discard})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"sh"}, commands)
+}
+
+func TestSharedCachedTokens(t *testing.T) {
+	home, _ := ioutil.TempDir(os.TempDir(), "home")
+	defer os.RemoveAll(home)
+	originalHome := os.Getenv("HOME")
+	defer os.Setenv("HOME", originalHome)
+	os.Setenv("HOME", home)
+
+	name := sharedTokensName("https://dex.ft.com/", clientID)
+	assert.Equal(t, name, sharedTokensName("https://dex.ft.com", clientID))
+	assert.NotEqual(t, name, sharedTokensName("https://dex.ft.com", "upp-github"))
+
+	for _, cluster := range []string{"config1", "config2"} {
+		assert.NoError(t, saveCachedTokens(cluster, &cachedTokens{IDToken: "id-token", RefreshToken: "refresh", Shared: name}))
+	}
+	// the refresh of one cluster rotates the refresh token of both
+	assert.NoError(t, saveCachedTokens("config1", &cachedTokens{IDToken: "new-id-token", RefreshToken: "new-refresh", Shared: name}))
+	tokens, err := loadCachedTokens("config2")
+	assert.NoError(t, err)
+	assert.Equal(t, &cachedTokens{IDToken: "new-id-token", RefreshToken: "new-refresh", Shared: name}, tokens)
+
+	assert.True(t, sharedTokensInUse(name, "config1"))
+	os.Remove(tokenCachePath("config2"))
+	assert.False(t, sharedTokensInUse(name, "config1"))
+}
@@ -8,8 +8,8 @@ import (
 	"strings"
 )
 
-func readTokens() string {
+func readTokens() (string, error) {
 	scanner := bufio.NewScanner(os.Stdin)
 	scanner.Scan()
-	return strings.TrimSpace(scanner.Text())
+	return strings.TrimSpace(scanner.Text()), scanner.Err()
 }
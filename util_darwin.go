@@ -10,7 +10,7 @@ import (
 const ioctlReadTermios = unix.TIOCGETA
 const ioctlWriteTermios = unix.TIOCSETA
 
-func readTokens() string {
+func readTokens() (string, error) {
 	// putting the terminal in noncanonical mode, as on macos, in canonical mode, the max length of
 	// a line is 1024 characters, which has the effect that only tokens less than 1023 characters can be read in canonical mode.
 	// Here are some useful links:
@@ -19,7 +19,7 @@ func readTokens() string {
 	stdinFd := int(os.Stdin.Fd())
 	termios, err := unix.IoctlGetTermios(stdinFd, ioctlReadTermios)
 	if err != nil {
-		logger.Fatalf("error: cannot read token from terminal: %v", err)
+		return "", err
 	}
 	defer unix.IoctlSetTermios(stdinFd, ioctlWriteTermios, termios)
 
@@ -27,10 +27,10 @@ func readTokens() string {
 	newState.Lflag &^= unix.ICANON
 
 	if err := unix.IoctlSetTermios(stdinFd, ioctlWriteTermios, &newState); err != nil {
-		logger.Fatalf("error: cannot read token from terminal: %v", err)
+		return "", err
 	}
 
 	scanner := bufio.NewScanner(os.Stdin)
 	scanner.Scan()
-	return strings.TrimSpace(scanner.Text())
+	return strings.TrimSpace(scanner.Text()), scanner.Err()
 }